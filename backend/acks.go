@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ackWindow is how long a ticket_critical alert may go unacknowledged before the on-call team
+// is emailed again (-ack-window, 0 = never)
+var ackWindow = 5 * time.Minute
+
+// TicketAck is one connection acknowledging a ticket_critical alert
+type TicketAck struct {
+	ID           int       `json:"id"`
+	TicketID     TicketID  `json:"ticket_id"`
+	AckID        string    `json:"ack_id"`
+	ConnectionID string    `json:"connection_id"`
+	Subject      string    `json:"subject,omitempty"`
+	AckedAt      time.Time `json:"acked_at"`
+}
+
+// errUnknownAck is returned by recordAck for an ack_id of no alert still in the replay window
+var errUnknownAck = errors.New("unknown or expired ack_id")
+
+// ackIDFor is the ack_id of the ticket_critical broadcast numbered seq. It is derived from the
+// seq, so a replayed alert keeps its ack_id and any instance can tell which ticket it is about.
+func ackIDFor(seq uint64) string {
+	return "crit-" + strconv.FormatUint(seq, 10)
+}
+
+// expectAck emails the on-call team again when nobody acknowledged the alert numbered seq
+// within ackWindow. The wait is not kept across restarts.
+func expectAck(seq uint64, about Ticket) {
+	if ackWindow <= 0 {
+		return
+	}
+	time.AfterFunc(ackWindow, func() { checkAck(ackIDFor(seq), about.ID) })
+}
+
+// checkAck escalates ackID about ticket id unless it was acknowledged, or the ticket is no longer
+// a critical one waiting for work
+func checkAck(ackID string, id TicketID) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ticket_acks WHERE ack_id = ?", ackID).Scan(&n); err != nil {
+		log.Printf("ack %s: %v", ackID, err)
+		return
+	}
+	if n > 0 {
+		return
+	}
+	t, err := loadTicket(ctx, db, id)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("ack %s: %v", ackID, err)
+		return
+	}
+	if t.Priority != "critical" || t.Status == "resolved" || t.Status == "closed" {
+		return
+	}
+	criticalUnacked.Inc()
+	log.Printf("ack %s: critical ticket %d not acknowledged within %s", ackID, id, ackWindow)
+	if !notifier.enabled() {
+		return
+	}
+	if err := notifier.NotifyUnacked(t, ackWindow); err != nil {
+		log.Printf("notify ticket %d: %v", id, err)
+	}
+}
+
+// recordAck stores that connection connID, with token subject, received the alert ackID and
+// returns the ticket it is about. Acknowledging twice from one connection is not an error.
+func recordAck(ctx context.Context, ackID, connID, subject string) (TicketID, error) {
+	seq, err := strconv.ParseUint(strings.TrimPrefix(ackID, "crit-"), 10, 64)
+	if err != nil || !strings.HasPrefix(ackID, "crit-") {
+		return 0, errUnknownAck
+	}
+	var id TicketID
+	err = db.QueryRowContext(ctx, "SELECT ticket_id FROM broadcast_events WHERE seq = ? AND event = 'ticket_critical'", seq).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownAck
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO ticket_acks (ticket_id, ack_id, connection_id, subject) VALUES (?, ?, ?, ?)", id, ackID, connID, subject)
+	if err != nil && !sqlDialect.IsDuplicateKey(err) {
+		return 0, err
+	}
+	return id, nil
+}
+
+// acksHandler serves GET /api/tickets/{id}/acks: who acknowledged the ticket's critical alerts, oldest first
+func acksHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id, ticket_id, ack_id, connection_id, subject, acked_at FROM ticket_acks WHERE ticket_id = ? ORDER BY acked_at, id", id)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	defer rows.Close()
+	res := []TicketAck{}
+	for rows.Next() {
+		var a TicketAck
+		if err := rows.Scan(&a.ID, &a.TicketID, &a.AckID, &a.ConnectionID, &a.Subject, &a.AckedAt); err != nil {
+			dbError(w, err)
+			return
+		}
+		inDisplayZone(&a.AckedAt)
+		res = append(res, a)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(w, err)
+		return
+	}
+	if len(res) == 0 {
+		if ok, err := ticketExists(ctx, id); err == nil && !ok {
+			writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+// checkAgents enables validating assignees against the agents table (-check-agents)
+var checkAgents bool
+
+// agentExists reports whether name is a known agent. Without -check-agents every name is accepted.
+func agentExists(ctx context.Context, name string) (bool, error) {
+	if !checkAgents {
+		return true, nil
+	}
+	var id int
+	err := db.QueryRowContext(ctx, "SELECT id FROM agents WHERE name = ?", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// validateAssignee writes a 422 and returns false when agent is set but unknown
+func validateAssignee(ctx context.Context, w http.ResponseWriter, agent *string) bool {
+	if agent == nil {
+		return true
+	}
+	ok, err := agentExists(ctx, *agent)
+	if err != nil {
+		dbError(w, err)
+		return false
+	}
+	if !ok {
+		writeFieldErrors(w, FieldErrors{"assigned_to": "unknown agent"})
+		return false
+	}
+	return true
+}
+
+// normalizeAgent maps an empty or blank assignee to nil (unassigned)
+func normalizeAgent(agent *string) *string {
+	if agent == nil {
+		return nil
+	}
+	a := strings.TrimSpace(*agent)
+	if a == "" {
+		return nil
+	}
+	return &a
+}
+
+// reassignAgentHandler serves POST /api/agents/{from}/reassign with {"to":"bob"}: every ticket
+// assigned to from that is not closed moves to to in one transaction, for an agent who leaves
+// or goes on vacation. Each moved ticket gets a "reassign" history entry and a ticket_assigned broadcast.
+func reassignAgentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	from := strings.TrimSpace(r.PathValue("from"))
+	if from == "" {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "agent is required")
+		return
+	}
+	var req struct {
+		To *string `json:"to"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	to := normalizeAgent(req.To)
+	if to == nil {
+		writeFieldErrors(w, FieldErrors{"to": "is required"})
+		return
+	}
+	if *to == from {
+		writeError(w, http.StatusBadRequest, "invalid_request", "cannot reassign tickets to the agent they are assigned to")
+		return
+	}
+	ok, err := agentExists(ctx, from)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "agent not found")
+		return
+	}
+	if ok, err = agentExists(ctx, *to); err != nil {
+		dbError(w, err)
+		return
+	}
+	if !ok {
+		writeFieldErrors(w, FieldErrors{"to": "unknown agent"})
+		return
+	}
+
+	actor := actorFromRequest(r)
+	var before, tickets []Ticket
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		before, err = queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE assigned_to = ? AND status <> 'closed' AND deleted_at IS NULL ORDER BY id", from)
+		if err != nil || len(before) == 0 {
+			return err
+		}
+		args := make([]interface{}, len(before))
+		for i, t := range before {
+			args[i] = t.ID
+		}
+		in := "id IN (" + placeholders(len(before)) + ")"
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET assigned_to = ?, version = version + 1 WHERE "+in, append([]interface{}{*to}, args...)...); err != nil {
+			return err
+		}
+		if tickets, err = queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+in+" ORDER BY id", args...); err != nil {
+			return err
+		}
+		for i := range tickets {
+			if err := writeAudit(ctx, tx, tickets[i].ID, "reassign", actor, &before[i], &tickets[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	ids := []TicketID{}
+	for _, t := range tickets {
+		ids = append(ids, t.ID)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"reassigned": len(tickets), "ids": ids})
+	ticketsUpdated.Add(float64(len(tickets)))
+	for _, t := range tickets {
+		broad.Broadcast("ticket_assigned", t, t)
+	}
+}
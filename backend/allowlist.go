@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+var (
+	// adminAllowlist are the networks admin routes answer (-admin-ip-allowlist); empty allows any
+	adminAllowlist []netip.Prefix
+	// trustedProxies are the proxies whose X-Forwarded-For is believed (-trusted-proxy)
+	trustedProxies []netip.Prefix
+)
+
+// parsePrefixes parses a comma separated list of CIDRs; a bare address is a network of one
+func parsePrefixes(raw string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(raw, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// inPrefixes reports whether addr is inside any of prefixes
+func inPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor is the client behind a trusted proxy: walking X-Forwarded-For from the right,
+// where the proxy nearest to us appended, the first address that is not a trusted proxy itself.
+// ok is false when peer is not a trusted proxy or the header has nothing usable.
+func forwardedFor(r *http.Request, peer netip.Addr) (client netip.Addr, ok bool) {
+	if !inPrefixes(trustedProxies, peer) {
+		return netip.Addr{}, false
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// anything left of a malformed entry may be made up
+			break
+		}
+		client, ok = addr.Unmap(), true
+		if !inPrefixes(trustedProxies, addr) {
+			break
+		}
+	}
+	return client, ok
+}
+
+// adminIPAllowed reports whether the client of r may use the admin routes
+func adminIPAllowed(r *http.Request) bool {
+	if len(adminAllowlist) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP(r))
+	return err == nil && inPrefixes(adminAllowlist, addr)
+}
+
+// adminNetwork answers 403 to clients outside -admin-ip-allowlist, before any token is looked at
+func adminNetwork(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminIPAllowed(r) {
+			writeError(w, http.StatusForbidden, "forbidden", "admin access is not allowed from this network")
+			return
+		}
+		next(w, r)
+	}
+}
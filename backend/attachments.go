@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	uploadsDir     = "uploads"       // -uploads-dir
+	maxUploadBytes = int64(10 << 20) // -max-upload-bytes
+)
+
+// uploadTypes maps every extension an attachment may be allowed to have to the content type
+// http.DetectContentType finds in such a file
+var uploadTypes = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif",
+	".webp": "image/webp", ".pdf": "application/pdf",
+}
+
+// allowedUploadExts are the attachment extensions accepted (-upload-extensions)
+var allowedUploadExts = []string{".jpg", ".jpeg", ".png", ".gif", ".webp", ".pdf"}
+
+// parseUploadExtensions parses -upload-extensions, e.g. "jpg,png,.pdf"; only extensions in uploadTypes are allowed
+func parseUploadExtensions(raw string) ([]string, error) {
+	var exts []string
+	for _, e := range strings.Split(raw, ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if _, ok := uploadTypes[e]; !ok {
+			return nil, fmt.Errorf("cannot check the contents of %s files", e)
+		}
+		exts = append(exts, e)
+	}
+	if len(exts) == 0 {
+		return nil, errors.New("no extensions given")
+	}
+	return exts, nil
+}
+
+// Attachment is a file uploaded for a ticket. Path is relative to uploadsDir.
+type Attachment struct {
+	ID           int       `json:"id"`
+	TicketID     TicketID  `json:"ticket_id,omitempty"`
+	OriginalName string    `json:"original_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Path         string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+const attachmentColumns = "id, ticket_id, original_name, content_type, size, path, created_at"
+
+func scanAttachment(s rowScanner, a *Attachment) error {
+	if err := s.Scan(&a.ID, &a.TicketID, &a.OriginalName, &a.ContentType, &a.Size, &a.Path, &a.CreatedAt); err != nil {
+		return err
+	}
+	inDisplayZone(&a.CreatedAt)
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// uploadAttachmentHandler serves POST /api/tickets/{id}/attachments with a multipart "file" field
+func uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	storeUpload(w, r, func(ctx context.Context) (Ticket, error) { return loadTicket(ctx, db, id) })
+}
+
+// publicUploadAttachmentHandler serves POST /api/public/tickets/{public_id}/attachments, for the
+// reporter who only knows the public_id. The answer leaves out the integer ticket_id.
+func publicUploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	publicID := r.PathValue("public_id")
+	storeUpload(w, r, func(ctx context.Context) (Ticket, error) { return loadPublicTicket(ctx, publicID) })
+}
+
+// storeUpload stores the multipart "file" field of r as an attachment of the ticket find returns.
+// The content type is sniffed from the data rather than trusted from the client.
+func storeUpload(w http.ResponseWriter, r *http.Request, find func(context.Context) (Ticket, error)) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	// leave some room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes+64<<10)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", "file too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "file is required")
+		return
+	}
+	defer file.Close()
+	if header.Size > maxUploadBytes {
+		writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", "file too large")
+		return
+	}
+	t, err := find(ctx)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	} else if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(file, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid_request", "could not read file")
+		return
+	}
+	if n == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "file is empty")
+		return
+	}
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !slices.Contains(allowedUploadExts, ext) {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("file extension %q not allowed (allowed: %s)", ext, strings.Join(allowedUploadExts, ", ")))
+		return
+	}
+	// the name is the client's claim, the bytes decide: a program renamed to .jpg is refused here
+	ctype, _, _ := mime.ParseMediaType(http.DetectContentType(sniff[:n]))
+	if ctype != uploadTypes[ext] {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type",
+			fmt.Sprintf("file content is %s, which does not match its %s extension", ctype, ext))
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+
+	name, err := newUUID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	full := filepath.Join(uploadsDir, name)
+	out, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		log.Printf("attachment create: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "could not store file")
+		return
+	}
+	size, err := io.Copy(out, file)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(full)
+		log.Printf("attachment write: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal_error", "could not store file")
+		return
+	}
+
+	a := Attachment{TicketID: t.ID, OriginalName: filepath.Base(header.Filename), ContentType: ctype, Size: size, Path: name}
+	var res sql.Result
+	err = withRetry(ctx, func() error {
+		res, err = db.ExecContext(ctx, "INSERT INTO attachments (ticket_id, original_name, content_type, size, path) VALUES (?, ?, ?, ?, ?)",
+			a.TicketID, a.OriginalName, a.ContentType, a.Size, a.Path)
+		return err
+	})
+	if err != nil {
+		os.Remove(full)
+		dbError(w, err)
+		return
+	}
+	aid, _ := res.LastInsertId()
+	a.ID = int(aid)
+	_ = db.QueryRowContext(ctx, "SELECT created_at FROM attachments WHERE id = ?", aid).Scan(&a.CreatedAt)
+	inDisplayZone(&a.CreatedAt)
+
+	// the public_id route was addressed without the integer id, so it doesn't answer with it
+	body := a
+	if r.PathValue("public_id") != "" {
+		body.TicketID = 0
+	}
+	writeJSON(w, http.StatusCreated, body)
+	broad.Broadcast("attachment_added", t, map[string]interface{}{"ticket_id": t.ID, "attachment": a})
+}
+
+// listAttachmentsHandler serves GET /api/tickets/{id}/attachments, oldest first
+func listAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	if ok, err := ticketExists(ctx, id); err != nil {
+		dbError(w, err)
+		return
+	} else if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	}
+	res, err := queryAttachments(ctx, db, id)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// queryAttachments returns the attachments of a ticket, oldest first (never nil)
+func queryAttachments(ctx context.Context, q querier, ticketID TicketID) ([]Attachment, error) {
+	rows, err := q.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE ticket_id = ? ORDER BY id", ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+// downloadAttachmentHandler serves GET /api/attachments/{id} with the stored content type.
+// Attachments of soft-deleted tickets are hidden like the ticket itself.
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var a Attachment
+	err = scanAttachment(db.QueryRowContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id = ? AND ticket_id IN (SELECT id FROM tickets WHERE deleted_at IS NULL)", id), &a)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "attachment not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	f, err := os.Open(filepath.Join(uploadsDir, a.Path))
+	if err != nil {
+		log.Printf("attachment %d: %v", a.ID, err)
+		writeError(w, http.StatusNotFound, "not_found", "attachment not found")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": a.OriginalName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, a.OriginalName, a.CreatedAt, f)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var (
+	pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+	pdfData = []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n%%EOF\n")
+	exeData = []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00\xb8\x00\x00\x00\x00\x00\x00\x00@\x00")
+)
+
+// upload posts data as the "file" field of an attachment for ticket id, declared as ctype
+func upload(t *testing.T, id TicketID, filename, ctype string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	r := uploadRequest(t, "/api/tickets/"+fmt.Sprint(id)+"/attachments", filename, ctype, data)
+	r.SetPathValue("id", fmt.Sprint(id))
+	w := httptest.NewRecorder()
+	uploadAttachmentHandler(w, r)
+	return w
+}
+
+// uploadRequest is a multipart POST to target with data as its "file" field, declared as ctype
+func uploadRequest(t *testing.T, target, filename, ctype string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	h.Set("Content-Type", ctype)
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// useUploadsDir stores uploads in a temporary directory for the length of the test
+func useUploadsDir(t *testing.T) {
+	t.Helper()
+	prev := uploadsDir
+	uploadsDir = t.TempDir()
+	t.Cleanup(func() { uploadsDir = prev })
+}
+
+func TestUploadSniffsContentType(t *testing.T) {
+	openTestDB(t)
+	useUploadsDir(t)
+	tk := createTestTicket(t, Ticket{Description: "proyektor mati"})
+	for _, tc := range []struct {
+		filename, declared, want string
+		data                     []byte
+	}{
+		{"foto.png", "image/png", "image/png", pngData},
+		{"FOTO.PNG", "application/octet-stream", "image/png", pngData},
+		{"laporan.pdf", "text/plain", "application/pdf", pdfData},
+	} {
+		w := upload(t, tk.ID, tc.filename, tc.declared, tc.data)
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: %d %s", tc.filename, w.Code, w.Body)
+			continue
+		}
+		m := decodeBody(t, w)
+		if m["content_type"] != tc.want || m["size"] != float64(len(tc.data)) {
+			t.Errorf("%s declared %s stored as %v, %v bytes; want %s, %d", tc.filename, tc.declared, m["content_type"], m["size"], tc.want, len(tc.data))
+		}
+	}
+}
+
+func TestUploadRejectsMismatches(t *testing.T) {
+	openTestDB(t)
+	useUploadsDir(t)
+	tk := createTestTicket(t, Ticket{Description: "proyektor mati"})
+	for _, tc := range []struct {
+		filename, declared string
+		data               []byte
+		status             int
+	}{
+		// a program renamed to look like a photo
+		{"foto.jpg", "image/jpeg", exeData, http.StatusUnsupportedMediaType},
+		{"foto.png", "image/png", exeData, http.StatusUnsupportedMediaType},
+		{"setup.exe", "image/png", exeData, http.StatusUnsupportedMediaType},
+		{"laporan.pdf", "application/pdf", pngData, http.StatusUnsupportedMediaType},
+		{"catatan.txt", "text/plain", []byte("lampu mati"), http.StatusUnsupportedMediaType},
+		{"foto.png", "image/png", nil, http.StatusBadRequest},
+	} {
+		w := upload(t, tk.ID, tc.filename, tc.declared, tc.data)
+		if w.Code != tc.status {
+			t.Errorf("%s with %d bytes: %d %s, want %d", tc.filename, len(tc.data), w.Code, w.Body, tc.status)
+		}
+	}
+	var n int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM attachments").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 || len(files) != 0 {
+		t.Errorf("refused uploads left %d rows and %d files", n, len(files))
+	}
+}
+
+func TestPublicUpload(t *testing.T) {
+	openTestDB(t)
+	useUploadsDir(t)
+	tk := createTestTicket(t, Ticket{Description: "proyektor mati"})
+	post := func(publicID string, data []byte) *httptest.ResponseRecorder {
+		r := uploadRequest(t, "/api/public/tickets/"+publicID+"/attachments", "foto.png", "image/png", data)
+		r.SetPathValue("public_id", publicID)
+		w := httptest.NewRecorder()
+		publicUploadAttachmentHandler(w, r)
+		return w
+	}
+	w := post(tk.PublicID, pngData)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload by public_id: %d %s", w.Code, w.Body)
+	}
+	if m := decodeBody(t, w); m["ticket_id"] != nil || m["content_type"] != "image/png" {
+		t.Errorf("upload by public_id answered %s", w.Body)
+	}
+	list, err := queryAttachments(context.Background(), db, tk.ID)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("attachments of the ticket: %v, %v", list, err)
+	}
+	if w := post("0123456789abcdef0123456789abcdef", pngData); w.Code != http.StatusNotFound {
+		t.Errorf("unknown public_id: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestUploadByIDHidden(t *testing.T) {
+	openTestDB(t)
+	useUploadsDir(t)
+	prevFlag, prevSecret := publicIDsOnly, jwtSecret
+	t.Cleanup(func() { publicIDsOnly, jwtSecret = prevFlag, prevSecret })
+	publicIDsOnly, jwtSecret = true, []byte("test-secret")
+	tk := createTestTicket(t, Ticket{Description: "proyektor mati"})
+	h := hideIntegerIDs(uploadAttachmentHandler)
+	post := func(id TicketID, data []byte, token string) *httptest.ResponseRecorder {
+		r := uploadRequest(t, "/api/tickets/"+fmt.Sprint(id)+"/attachments", "foto.png", "image/png", data)
+		r.SetPathValue("id", fmt.Sprint(id))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	// without a token an existing ticket, a missing one and a bad file all look alike
+	var bodies []string
+	for _, w := range []*httptest.ResponseRecorder{post(tk.ID, pngData, ""), post(tk.ID+1, pngData, ""), post(tk.ID, exeData, ""), post(tk.ID, nil, "")} {
+		if w.Code != http.StatusNotFound {
+			t.Errorf("without a token: %d %s, want 404", w.Code, w.Body)
+		}
+		bodies = append(bodies, w.Body.String())
+	}
+	if len(slices.Compact(bodies)) != 1 {
+		t.Errorf("answers differ: %q", bodies)
+	}
+	if w := post(tk.ID, pngData, testToken(t, "admin")); w.Code != http.StatusCreated {
+		t.Errorf("with a token: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestParseUploadExtensions(t *testing.T) {
+	got, err := parseUploadExtensions(" JPG, .png,pdf,")
+	if err != nil || strings.Join(got, " ") != ".jpg .png .pdf" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, raw := range []string{"", " , ", "png,exe", "svg"} {
+		if _, err := parseUploadExtensions(raw); err == nil {
+			t.Errorf("%q: no error", raw)
+		}
+	}
+}
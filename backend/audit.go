@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// AuditEntry is one recorded change of a ticket, with the ticket before and after
+type AuditEntry struct {
+	ID       int             `json:"id"`
+	TicketID TicketID        `json:"ticket_id"`
+	Action   string          `json:"action"`
+	Actor    string          `json:"actor"`
+	OldValue json.RawMessage `json:"old_value"`
+	NewValue json.RawMessage `json:"new_value"`
+	// Reason is why the status changed, when the change came with one
+	Reason    *string   `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// actorFromRequest names who is making the change: the token subject, or "public"
+func actorFromRequest(r *http.Request) string {
+	if claims, ok := claimsFromContext(r.Context()); ok && claims.Subject != "" {
+		return claims.Subject
+	}
+	return "public"
+}
+
+// nullableJSON marshals t, or returns nil so the column is stored as NULL
+func nullableJSON(t *Ticket) (interface{}, error) {
+	if t == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// writeAudit records a change of ticketID; run it in the transaction making the change
+func writeAudit(ctx context.Context, q querier, ticketID TicketID, action, actor string, oldT, newT *Ticket) error {
+	return writeAuditReason(ctx, q, ticketID, action, actor, "", oldT, newT)
+}
+
+// writeAuditReason is writeAudit for a change that came with a reason; "" stores none
+func writeAuditReason(ctx context.Context, q querier, ticketID TicketID, action, actor, reason string, oldT, newT *Ticket) error {
+	oldV, err := nullableJSON(oldT)
+	if err != nil {
+		return err
+	}
+	newV, err := nullableJSON(newT)
+	if err != nil {
+		return err
+	}
+	var r interface{}
+	if reason != "" {
+		r = reason
+	}
+	_, err = q.ExecContext(ctx, "INSERT INTO audit_log (ticket_id, action, actor, old_value, new_value, reason) VALUES (?, ?, ?, ?, ?, ?)",
+		ticketID, action, actor, oldV, newV, r)
+	return err
+}
+
+// historyHandler serves GET /api/tickets/{id}/history, oldest entry first
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	res, err := queryHistory(ctx, db, id)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if len(res) == 0 {
+		if ok, err := ticketExists(ctx, id); err == nil && !ok {
+			writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// queryHistory returns the audit entries of a ticket, oldest first (never nil)
+func queryHistory(ctx context.Context, q querier, ticketID TicketID) ([]AuditEntry, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, ticket_id, action, actor, old_value, new_value, reason, created_at FROM audit_log WHERE ticket_id = ? ORDER BY created_at, id", ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var oldV, newV []byte
+		if err := rows.Scan(&e.ID, &e.TicketID, &e.Action, &e.Actor, &oldV, &newV, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		inDisplayZone(&e.CreatedAt)
+		if oldV != nil {
+			e.OldValue = oldV
+		}
+		if newV != nil {
+			e.NewValue = newV
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtSecret is the HMAC key for admin tokens (-jwt-secret). Empty disables auth for local dev.
+var jwtSecret []byte
+
+// Claims carried by admin tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	// Role is one of roles; tokens without one get defaultRole
+	Role string `json:"role,omitempty"`
+}
+
+// roles from least to most privileged; a role may do everything the ones before it may.
+// viewer reads tickets and follows the live updates, admin also changes them.
+var roles = []string{"viewer", "admin"}
+
+// defaultRole is the role of tokens without a role claim (-default-role), e.g. ones issued before roles existed
+var defaultRole = "admin"
+
+// role returns the role the claims grant, defaultRole when they name none
+func (c *Claims) role() string {
+	if c.Role == "" {
+		return defaultRole
+	}
+	return c.Role
+}
+
+// hasRole reports whether c grants role or a more privileged one. Unknown roles grant nothing.
+func (c *Claims) hasRole(role string) bool {
+	have := slices.Index(roles, c.role())
+	return have >= 0 && have >= slices.Index(roles, role)
+}
+
+type ctxKey int
+
+const claimsKey ctxKey = iota
+
+// parseToken validates a signed, unexpired HS256 token and returns its claims
+func parseToken(raw string) (*Claims, error) {
+	if raw == "" {
+		return nil, errors.New("missing token")
+	}
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(*jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		return strings.TrimSpace(h[7:])
+	}
+	return ""
+}
+
+// wsToken returns the ?token= query parameter, falling back to the Authorization header.
+// Browsers cannot set headers on a websocket handshake, so the admin page uses the query.
+func wsToken(r *http.Request) string {
+	if t := r.URL.Query().Get("token"); t != "" {
+		return t
+	}
+	return bearerToken(r)
+}
+
+// claimsFromContext returns the claims stashed by authMiddleware, if any
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(*Claims)
+	return c, ok
+}
+
+// tokenSubject is the sub of raw, or "" when it has none or is not a valid token
+func tokenSubject(raw string) string {
+	claims, err := parseToken(raw)
+	if err != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+// checkRole answers 401 or 403 and returns false unless raw is a valid token granting role.
+// It is for the websocket and event stream handlers, which take the token from ?token= too.
+func checkRole(w http.ResponseWriter, raw, role string) bool {
+	if len(jwtSecret) == 0 {
+		return true
+	}
+	claims, err := parseToken(raw)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized")
+		return false
+	}
+	if !claims.hasRole(role) {
+		writeError(w, http.StatusForbidden, "forbidden", "requires role "+role)
+		return false
+	}
+	return true
+}
+
+// isAdmin reports whether r carries a valid admin token (always true when auth is disabled).
+// Outside -admin-ip-allowlist nobody is, so public routes never show more there.
+func isAdmin(r *http.Request) bool {
+	if !adminIPAllowed(r) {
+		return false
+	}
+	if len(jwtSecret) == 0 {
+		return true
+	}
+	_, err := parseToken(bearerToken(r))
+	return err == nil
+}
+
+// authMiddleware rejects requests without a valid Bearer JWT and stores the claims in the request context
+func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(jwtSecret) == 0 {
+			next(w, r)
+			return
+		}
+		claims, err := parseToken(bearerToken(r))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+	}
+}
+
+// requireRole is authMiddleware that also needs the token to grant role, answering 403 otherwise.
+// Clients outside -admin-ip-allowlist get the 403 without the token being checked.
+func requireRole(role string) func(http.HandlerFunc) http.HandlerFunc {
+	if !slices.Contains(roles, role) {
+		panic(fmt.Sprintf("requireRole: unknown role %q", role))
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return adminNetwork(authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := claimsFromContext(r.Context()); ok && !claims.hasRole(role) {
+				writeError(w, http.StatusForbidden, "forbidden", "requires role "+role)
+				return
+			}
+			next(w, r)
+		}))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// watchAutoClose closes tickets left in resolved for longer than after, checking every interval
+func watchAutoClose(interval, after time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		n, err := autoCloseResolved(ctx, after)
+		cancel()
+		if err != nil {
+			log.Printf("autoclose: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("autoclose: closed %d stale resolved tickets", n)
+		}
+	}
+}
+
+// autoCloseResolved closes the stale resolved tickets in one transaction and broadcasts each as ticket_updated
+func autoCloseResolved(ctx context.Context, after time.Duration) (int, error) {
+	var closed, closedBefore []Ticket
+	err := inTx(ctx, func(tx *timedTx) error {
+		closed, closedBefore = nil, nil
+		cond := "status = 'resolved' AND deleted_at IS NULL AND updated_at < " + sqlDialect.AddSeconds(sqlDialect.Now())
+		before, err := queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+cond+" ORDER BY id", -int(after.Seconds()))
+		if err != nil {
+			return err
+		}
+		for i := range before {
+			// re-check the status so a ticket reopened meanwhile is left alone
+			res, err := tx.ExecContext(ctx, "UPDATE tickets SET status = 'closed', version = version + 1 WHERE id = ? AND status = 'resolved'", before[i].ID)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue
+			}
+			t, err := loadTicket(ctx, tx, before[i].ID)
+			if err != nil {
+				return err
+			}
+			if err := writeAudit(ctx, tx, t.ID, "auto_close", "system", &before[i], &t); err != nil {
+				return err
+			}
+			closed = append(closed, t)
+			closedBefore = append(closedBefore, before[i])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	ticketsUpdated.Add(float64(len(closed)))
+	for i, t := range closed {
+		broad.Broadcast("ticket_updated", t, updatedPayload(closedBefore[i], t, ""))
+	}
+	return len(closed), nil
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// pingPeriod must stay below pongWait so a healthy client always answers in time
+	pingPeriod = 30 * time.Second
+	pongWait   = 60 * time.Second
+	writeWait  = 5 * time.Second
+	// sendQueueSize is how many messages a connection may fall behind before it is dropped
+	sendQueueSize = 64
+	// replayWindow is how many recent broadcasts are kept for resyncing clients
+	replayWindow = 1000
+)
+
+// coalesceWindow is how long ticket_updated events for one ticket are coalesced (-broadcast-coalesce-ms);
+// zero sends every one
+var coalesceWindow = 200 * time.Millisecond
+
+// reasons a connection is dropped or a write fails, used in BroadcasterStats and the metric labels
+const (
+	reasonQueueFull  = "queue_full"
+	reasonWriteError = "write_error"
+	reasonDeadline   = "deadline_exceeded"
+)
+
+// subscriber is an admin connection the Broadcaster delivers to: a *websocket.Conn or an *sseStream
+type subscriber interface {
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// wsClient is one registered subscriber; only its writer (writeLoop, or streamHandler
+// for SSE) reads send and writes to the connection
+type wsClient struct {
+	send   chan []byte
+	filter wsFilter
+	b      *Broadcaster
+	// id names the connection for POST /api/ws/disconnect; subject is the token's sub, if any
+	id          string
+	subject     string
+	connectedAt time.Time
+	// bye is the close frame writeLoop sends once send is closed; nil means a plain normal closure
+	bye []byte
+}
+
+// wsFilter is a connection's subscription; an empty list matches every value
+type wsFilter struct {
+	Priority []string `json:"priority"`
+	Room     []string `json:"room"`
+	// TicketID limits an SSE stream to one ticket (GET /api/tickets/{id}/events)
+	TicketID TicketID `json:"-"`
+}
+
+// matches reports whether events about t should reach a connection subscribed with f.
+// Events about no single ticket (a zero Ticket, like tickets_imported) reach everyone.
+func (f wsFilter) matches(t Ticket) bool {
+	if t.ID == 0 {
+		return true
+	}
+	return (len(f.Priority) == 0 || slices.Contains(f.Priority, t.Priority)) &&
+		(len(f.Room) == 0 || slices.Contains(f.Room, t.Room)) &&
+		(f.TicketID == 0 || f.TicketID == t.ID)
+}
+
+// broadcaster: manages admin websocket and SSE connections and broadcasting messages
+type Broadcaster struct {
+	mu      sync.Mutex
+	clients map[subscriber]*wsClient
+	// websockets counts the websocket clients, which maxWsConnections caps; event streams are not counted
+	websockets int
+	// seq numbers every broadcast; recent holds the last replayWindow of them, oldest first
+	seq    uint64
+	recent []sentEvent
+	// persist stores every broadcast in broadcast_events, set once restoreEvents has run. The
+	// broadcasts then wait in unsaved until storeEvents has numbered and sent them.
+	persist bool
+	unsaved []unsavedEvent
+	// wake tells storeEvents there is something in unsaved; storing is set while it writes a
+	// batch, and stored is signalled after each one
+	wake    chan struct{}
+	storing bool
+	stored  *sync.Cond
+	// pending holds, by ticket id, the updates coalesced within the current window
+	pending map[TicketID]*pendingUpdate
+
+	// writers counts running writeLoops, so Shutdown can wait for the goodbyes to go out
+	writers sync.WaitGroup
+
+	// statsMu guards the counters separately, writeLoops update them without holding mu
+	statsMu      sync.Mutex
+	dropped      map[string]uint64
+	failedWrites map[string]uint64
+}
+
+// BroadcasterStats is a snapshot of the Broadcaster's counters since startup, keyed by reason
+type BroadcasterStats struct {
+	Connections int               `json:"connections"`
+	Dropped     map[string]uint64 `json:"dropped"`
+	// FailedWrites also counts close frames that could not be sent to an already dropped connection
+	FailedWrites map[string]uint64 `json:"failed_writes"`
+}
+
+// pendingUpdate is a ticket's coalescing window: the latest ticket_updated held back, if any,
+// and the timer that sends it when the window ends
+type pendingUpdate struct {
+	held    bool
+	about   Ticket
+	payload interface{}
+	timer   *time.Timer
+}
+
+// sentEvent is a broadcast kept for replay, with the ticket its filter check needs
+type sentEvent struct {
+	seq   uint64
+	about Ticket
+	data  []byte
+}
+
+// NewBroadcaster starts the sequence at the boot time in milliseconds, so numbers a client
+// kept from before a restart are always older than the replay window and never replayed wrongly.
+// restoreEvents hands the numbering to the database instead.
+func NewBroadcaster() *Broadcaster {
+	b := &Broadcaster{
+		seq:          uint64(time.Now().UnixMilli()),
+		clients:      make(map[subscriber]*wsClient),
+		pending:      make(map[TicketID]*pendingUpdate),
+		wake:         make(chan struct{}, 1),
+		dropped:      make(map[string]uint64),
+		failedWrites: make(map[string]uint64),
+	}
+	b.stored = sync.NewCond(&b.mu)
+	return b
+}
+
+// Stats returns the current connection count and the drop and write failure counters
+func (b *Broadcaster) Stats() BroadcasterStats {
+	n := b.Count()
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+	return BroadcasterStats{Connections: n, Dropped: maps.Clone(b.dropped), FailedWrites: maps.Clone(b.failedWrites)}
+}
+
+// Count returns how many connections are registered
+func (b *Broadcaster) Count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// ConnectionCounts breaks the registered connections down by transport and by subscribed room
+type ConnectionCounts struct {
+	Count int `json:"count"`
+	// ByTransport is keyed "websocket" or "sse"
+	ByTransport map[string]int `json:"by_transport"`
+	// ByRoom counts the connections subscribed to each room; unfiltered ones are not in it
+	ByRoom map[string]int `json:"by_room"`
+	// Connections lists every connection, oldest first
+	Connections []ConnectionInfo `json:"connections"`
+}
+
+// ConnectionInfo describes one registered connection
+type ConnectionInfo struct {
+	ID          string    `json:"id"`
+	Transport   string    `json:"transport"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Subject     string    `json:"subject,omitempty"`
+	Room        []string  `json:"room,omitempty"`
+	Priority    []string  `json:"priority,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Connections returns the current ConnectionCounts
+func (b *Broadcaster) Connections() ConnectionCounts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cc := ConnectionCounts{Count: len(b.clients), ByTransport: map[string]int{"websocket": 0, "sse": 0}, ByRoom: map[string]int{},
+		Connections: []ConnectionInfo{}}
+	for c, cl := range b.clients {
+		transport := "websocket"
+		if _, ok := c.(*sseStream); ok {
+			transport = "sse"
+		}
+		cc.ByTransport[transport]++
+		for _, room := range cl.filter.Room {
+			cc.ByRoom[room]++
+		}
+		cc.Connections = append(cc.Connections, ConnectionInfo{ID: cl.id, Transport: transport, RemoteAddr: c.RemoteAddr().String(),
+			Subject: cl.subject, Room: cl.filter.Room, Priority: cl.filter.Priority, ConnectedAt: cl.connectedAt.In(displayLoc)})
+	}
+	slices.SortFunc(cc.Connections, func(a, b ConnectionInfo) int { return a.ConnectedAt.Compare(b.ConnectedAt) })
+	return cc
+}
+
+// countDrop records that the connection to addr was given up on
+func (b *Broadcaster) countDrop(addr net.Addr, reason string, err error) {
+	b.statsMu.Lock()
+	b.dropped[reason]++
+	total := b.dropped[reason]
+	b.statsMu.Unlock()
+	wsDropped.WithLabelValues(reason).Inc()
+	if err != nil {
+		log.Printf("ws dropping %s: %s: %v (%d dropped for this reason so far)", addr, reason, err, total)
+	} else {
+		log.Printf("ws dropping %s: %s (%d dropped for this reason so far)", addr, reason, total)
+	}
+}
+
+// countFailedWrite records a write error and returns its reason
+func (b *Broadcaster) countFailedWrite(err error) string {
+	reason := reasonWriteError
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		reason = reasonDeadline
+	}
+	b.statsMu.Lock()
+	b.failedWrites[reason]++
+	b.statsMu.Unlock()
+	wsFailedWrites.WithLabelValues(reason).Inc()
+	return reason
+}
+
+// maxWsConnections caps the open websocket connections (-max-ws-connections, 0 = no limit)
+var maxWsConnections = 1000
+
+// WsFull reports whether another websocket would exceed maxWsConnections
+func (b *Broadcaster) WsFull() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.wsFull()
+}
+
+// wsFull is WsFull with b.mu held
+func (b *Broadcaster) wsFull() bool {
+	return maxWsConnections > 0 && b.websockets >= maxWsConnections
+}
+
+// Add registers c with filter f for the token subject and starts its writer goroutine.
+// It returns false, registering nothing, when maxWsConnections are already open.
+func (b *Broadcaster) Add(c *websocket.Conn, f wsFilter, subject string) bool {
+	cl := b.register(c, f, subject)
+	if cl == nil {
+		return false
+	}
+	go func() {
+		defer b.writers.Done()
+		cl.writeLoop(c)
+	}()
+	return true
+}
+
+// AddStream registers s with filter f and returns its queue, which the caller drains;
+// the queue is closed once s is dropped
+func (b *Broadcaster) AddStream(s *sseStream, f wsFilter, subject string) <-chan []byte {
+	return b.register(s, f, subject).send
+}
+
+// register adds c to the clients; it returns nil for a websocket over maxWsConnections
+func (b *Broadcaster) register(c subscriber, f wsFilter, subject string) *wsClient {
+	cl := &wsClient{send: make(chan []byte, sendQueueSize), filter: f, b: b, id: newConnID(), subject: subject, connectedAt: time.Now()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := c.(*websocket.Conn); ok {
+		if b.wsFull() {
+			return nil
+		}
+		b.websockets++
+		// counted under b.mu, so a Shutdown taking it next waits for this writer too
+		b.writers.Add(1)
+		if b.wsFull() {
+			log.Printf("ws: %d connections open, the -max-ws-connections limit; refusing new ones until some close", b.websockets)
+		}
+	}
+	b.clients[c] = cl
+	wsConnections.Set(float64(len(b.clients)))
+	return cl
+}
+
+func (b *Broadcaster) Remove(c subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(c)
+}
+
+// drop forgets c and stops its writer; b.mu must be held. Dropping twice is harmless.
+func (b *Broadcaster) drop(c subscriber) {
+	cl, ok := b.clients[c]
+	if !ok {
+		return
+	}
+	delete(b.clients, c)
+	close(cl.send)
+	if _, ok := c.(*websocket.Conn); ok {
+		b.websockets--
+	}
+	wsConnections.Set(float64(len(b.clients)))
+}
+
+// newConnID returns a random (version 4) UUID
+func newConnID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// CloseConn disconnects the connection with the given id: a websocket gets a policy violation
+// close frame, an event stream ends. It reports whether the connection existed.
+func (b *Broadcaster) CloseConn(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c, cl := range b.clients {
+		if cl.id != id {
+			continue
+		}
+		cl.bye = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by an admin")
+		b.drop(c)
+		return true
+	}
+	return false
+}
+
+// ConnID is the id of connection c, empty once it is dropped
+func (b *Broadcaster) ConnID(c subscriber) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cl, ok := b.clients[c]; ok {
+		return cl.id
+	}
+	return ""
+}
+
+// Subscribe replaces the filter of c; later broadcasts only reach it when about matches
+func (b *Broadcaster) Subscribe(c subscriber, f wsFilter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cl, ok := b.clients[c]; ok {
+		cl.filter = f
+	}
+}
+
+// Broadcast queues the event for every connection subscribed to the ticket it is about,
+// without waiting on any of them. Every broadcast carries a "seq" one higher than the one before.
+//
+// A ticket_updated goes out at once, but further ones for the same ticket within coalesceWindow
+// are held back and only the latest is sent when the window ends, so bulk edits do not flood the
+// dashboards. Any other event about that ticket sends the held update first, keeping the order.
+func (b *Broadcaster) Broadcast(event string, about Ticket, payload interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if coalesceWindow > 0 && about.ID != 0 {
+		p := b.pending[about.ID]
+		switch {
+		case event == "ticket_updated" && p != nil:
+			if p.held {
+				wsCoalesced.Inc()
+				// diffs add up, so the update sent still covers every field that changed
+				if d, ok := payload.(ticketDiff); ok {
+					if h, ok := p.payload.(ticketDiff); ok {
+						payload = h.then(d)
+					}
+				}
+			}
+			p.held, p.about, p.payload = true, about, payload
+			return
+		case event == "ticket_updated":
+			b.openWindow(about.ID)
+		case p != nil && p.held:
+			b.send("ticket_updated", p.about, p.payload)
+			p.held, p.payload = false, nil
+		}
+	}
+	b.send(event, about, payload)
+}
+
+// openWindow starts coalescing updates for ticket id; b.mu must be held
+func (b *Broadcaster) openWindow(id TicketID) {
+	p := &pendingUpdate{}
+	b.pending[id] = p
+	p.timer = time.AfterFunc(coalesceWindow, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.pending[id] != p {
+			return
+		}
+		if !p.held {
+			delete(b.pending, id)
+			return
+		}
+		// the held update opens a new window, so a steady stream still goes out once per window
+		b.send("ticket_updated", p.about, p.payload)
+		p.held, p.payload = false, nil
+		p.timer.Reset(coalesceWindow)
+	})
+}
+
+// flushPending sends every held update and closes all windows; b.mu must be held
+func (b *Broadcaster) flushPending() {
+	for id, p := range b.pending {
+		p.timer.Stop()
+		if p.held {
+			b.send("ticket_updated", p.about, p.payload)
+		}
+		delete(b.pending, id)
+	}
+}
+
+// send numbers the event, keeps it for replay and queues it for the matching connections; b.mu
+// must be held. Once broadcasts are persisted it only hands the event to storeEvents, so the
+// database write never happens under b.mu.
+func (b *Broadcaster) send(event string, about Ticket, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("ws encode %s: %v", event, err)
+		return
+	}
+	if b.persist {
+		b.unsaved = append(b.unsaved, unsavedEvent{event, about, raw})
+		select {
+		case b.wake <- struct{}{}:
+		default: // storeEvents is already due to look
+		}
+		return
+	}
+	b.deliver(event, about, raw, b.seq+1)
+}
+
+// deliver keeps the event numbered seq for replay and queues it for the matching connections;
+// b.mu must be held. An event without a seq, because it could not be stored, still goes out but
+// is not kept, so no client holds a cursor the stored events cannot answer.
+func (b *Broadcaster) deliver(event string, about Ticket, raw []byte, seq uint64) {
+	data, err := encodeSeqEvent(event, about, raw, seq)
+	if err != nil {
+		log.Printf("ws encode %s: %v", event, err)
+		return
+	}
+	if seq > 0 {
+		b.seq = seq
+		if event == "ticket_critical" {
+			expectAck(seq, about)
+		}
+		b.recent = append(b.recent, sentEvent{seq, about, data})
+		if len(b.recent) > replayWindow {
+			b.recent = slices.Delete(b.recent, 0, len(b.recent)-replayWindow)
+		}
+	}
+	for c, cl := range b.clients {
+		if cl.filter.matches(about) {
+			b.queue(c, cl, data)
+		}
+	}
+}
+
+// Seq is the number of the latest broadcast
+func (b *Broadcaster) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Resync sends c, in one "resync" message, the broadcasts after since that match its filter.
+// It returns false without sending anything when since is outside the replay window.
+func (b *Broadcaster) Resync(c subscriber, since uint64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cl, ok := b.clients[c]
+	if !ok {
+		return true
+	}
+	oldest := b.seq
+	if len(b.recent) > 0 {
+		oldest = b.recent[0].seq - 1
+	}
+	if since < oldest || since > b.seq {
+		return false
+	}
+	events := []json.RawMessage{}
+	for _, e := range b.recent {
+		if e.seq > since && cl.filter.matches(e.about) {
+			events = append(events, e.data)
+		}
+	}
+	data, err := encodeEvent("resync", map[string]interface{}{"events": events, "seq": b.seq})
+	if err != nil {
+		log.Printf("ws encode resync: %v", err)
+		return true
+	}
+	b.queue(c, cl, data)
+	return true
+}
+
+// Send queues the event for c alone
+func (b *Broadcaster) Send(c subscriber, event string, payload interface{}) {
+	data, err := encodeEvent(event, payload)
+	if err != nil {
+		log.Printf("ws encode %s: %v", event, err)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cl, ok := b.clients[c]; ok {
+		b.queue(c, cl, data)
+	}
+}
+
+// queue hands data to the writer of c without blocking; b.mu must be held.
+// A connection whose queue is full is too slow to keep up and gets disconnected.
+func (b *Broadcaster) queue(c subscriber, cl *wsClient, data []byte) {
+	select {
+	case cl.send <- data:
+	default:
+		b.countDrop(c.RemoteAddr(), reasonQueueFull, nil)
+		b.drop(c)
+		c.Close()
+	}
+}
+
+// Shutdown sends every connection a final server_shutdown event, then closes it with a normal
+// closure so clients know to back off and reconnect, and waits until the websocket goodbyes are
+// written or ctx is done. It returns how many connections there were.
+func (b *Broadcaster) Shutdown(ctx context.Context) int {
+	n := b.sayGoodbye(func(subscriber) bool { return true })
+	done := make(chan struct{})
+	go func() {
+		b.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("ws shutdown: %v before every goodbye was written", ctx.Err())
+	}
+	return n
+}
+
+// CloseStreams does the same for the SSE streams only, without waiting: unlike hijacked
+// websockets they are ordinary requests, and http.Server.Shutdown waits for them to return.
+func (b *Broadcaster) CloseStreams() int {
+	return b.sayGoodbye(func(c subscriber) bool {
+		_, ok := c.(*sseStream)
+		return ok
+	})
+}
+
+// sayGoodbye queues server_shutdown for the connections picked, then forgets them; their
+// writers send the event and the close frame and exit
+func (b *Broadcaster) sayGoodbye(pick func(subscriber) bool) int {
+	data, err := encodeEvent("server_shutdown", nil)
+	if err != nil {
+		log.Printf("ws encode server_shutdown: %v", err)
+	}
+	bye := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "server shutting down")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// the final state of every ticket goes out before the goodbye
+	b.flushPending()
+	b.waitStored()
+	n := 0
+	for c, cl := range b.clients {
+		if !pick(c) {
+			continue
+		}
+		n++
+		cl.bye = bye
+		if data != nil {
+			b.queue(c, cl, data)
+		}
+		b.drop(c)
+	}
+	return n
+}
+
+func encodeEvent(event string, payload interface{}) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"event": event, "payload": payload})
+}
+
+// writeLoop writes queued messages and pings on a timer, so sockets silently cut by
+// proxies are noticed without waiting for a broadcast. It exits when the queue is
+// closed or a write fails.
+func (cl *wsClient) writeLoop(conn *websocket.Conn) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case msg, ok := <-cl.send:
+			if !ok {
+				// already dropped, a failed goodbye only counts as a failed write
+				bye := cl.bye
+				if bye == nil {
+					bye = websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+				}
+				if err := conn.WriteControl(websocket.CloseMessage, bye, time.Now().Add(writeWait)); err != nil {
+					cl.b.countFailedWrite(err)
+				}
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				cl.b.countDrop(conn.RemoteAddr(), cl.b.countFailedWrite(err), err)
+				cl.b.Remove(conn)
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				cl.b.countDrop(conn.RemoteAddr(), cl.b.countFailedWrite(err), err)
+				cl.b.Remove(conn)
+				return
+			}
+		}
+	}
+}
+
+var broad = NewBroadcaster()
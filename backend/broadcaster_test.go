@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialBroadcaster serves websockets registered with b and returns a client connected to it
+func dialBroadcaster(t *testing.T, b *Broadcaster) *websocket.Conn {
+	t.Helper()
+	up := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		b.Add(c, wsFilter{}, "")
+	}))
+	t.Cleanup(srv.Close)
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	// Add runs after the handshake, so wait for the registration
+	for deadline := time.Now().Add(time.Second); b.Count() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("connection never registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return c
+}
+
+// waitConnections waits until n connections are registered with b
+func waitConnections(t *testing.T, b *Broadcaster, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); b.Count() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections registered, want %d", b.Count(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// useBroadcaster points broad at a new Broadcaster for the length of the test. The handlers
+// of connections still open at the end are waited for, as they use broad until they return.
+func useBroadcaster(t *testing.T) {
+	t.Helper()
+	prev := broad
+	broad = NewBroadcaster()
+	t.Cleanup(func() { broad = prev })
+	t.Cleanup(func() { waitConnections(t, broad, 0) })
+}
+
+func TestShutdownSaysGoodbye(t *testing.T) {
+	b := NewBroadcaster()
+	clients := []*websocket.Conn{dialBroadcaster(t, b), dialBroadcaster(t, b)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if n := b.Shutdown(ctx); n != 2 {
+		t.Fatalf("Shutdown said goodbye to %d connections, want 2", n)
+	}
+	if n := b.Count(); n != 0 {
+		t.Errorf("%d connections left registered", n)
+	}
+	for i, c := range clients {
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("client %d: %v before server_shutdown", i, err)
+		}
+		var m struct {
+			Event string `json:"event"`
+		}
+		if err := json.Unmarshal(msg, &m); err != nil || m.Event != "server_shutdown" {
+			t.Fatalf("client %d got %s, want server_shutdown", i, msg)
+		}
+		_, _, err = c.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Fatalf("client %d: %v, want a normal closure", i, err)
+		}
+	}
+}
+
+func TestShutdownSendsHeldUpdatesFirst(t *testing.T) {
+	prev := coalesceWindow
+	t.Cleanup(func() { coalesceWindow = prev })
+	coalesceWindow = time.Hour
+	b := NewBroadcaster()
+	c := dialBroadcaster(t, b)
+	about := Ticket{ID: 7}
+	b.Broadcast("ticket_updated", about, map[string]int{"version": 2})
+	b.Broadcast("ticket_updated", about, map[string]int{"version": 3}) // held back for the window
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	b.Shutdown(ctx)
+	var events []string
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+		var m struct {
+			Event   string          `json:"event"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		json.Unmarshal(msg, &m)
+		events = append(events, m.Event+" "+string(m.Payload))
+	}
+	want := []string{`ticket_updated {"version":2}`, `ticket_updated {"version":3}`, "server_shutdown null"}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// sentEvents lists what b has sent so far, as "event payload"
+func sentEvents(t *testing.T, b *Broadcaster) []string {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []string
+	for _, e := range b.recent {
+		var m struct {
+			Event   string          `json:"event"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(e.data, &m); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, m.Event+" "+string(m.Payload))
+	}
+	return out
+}
+
+// waitSent waits until b has sent n events and returns them
+func waitSent(t *testing.T, b *Broadcaster, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sent := sentEvents(t, b)
+		if len(sent) >= n || time.Now().After(deadline) {
+			return sent
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBroadcastCoalescesRapidUpdates(t *testing.T) {
+	prev := coalesceWindow
+	t.Cleanup(func() { coalesceWindow = prev })
+	coalesceWindow = 50 * time.Millisecond
+	b := NewBroadcaster()
+	one, two := Ticket{ID: 1}, Ticket{ID: 2}
+	for v := 1; v <= 5; v++ {
+		b.Broadcast("ticket_updated", one, map[string]int{"id": 1, "version": v})
+	}
+	b.Broadcast("ticket_updated", two, map[string]int{"id": 2, "version": 1})
+	b.Broadcast("comment_added", two, map[string]int{"ticket_id": 2})
+
+	// the first update of each ticket goes out at once, other events are never held
+	want := []string{
+		`ticket_updated {"id":1,"version":1}`,
+		`ticket_updated {"id":2,"version":1}`,
+		`comment_added {"ticket_id":2}`,
+	}
+	if got := sentEvents(t, b); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sent at once:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// when the window ends only the latest of the held updates follows
+	want = append(want, `ticket_updated {"id":1,"version":5}`)
+	waitSent(t, b, len(want))
+	time.Sleep(3 * coalesceWindow) // and nothing after it
+	if got := sentEvents(t, b); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sent:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBroadcastCoalescedDiffsAddUp(t *testing.T) {
+	prev := coalesceWindow
+	t.Cleanup(func() { coalesceWindow = prev })
+	coalesceWindow = time.Hour
+	b := NewBroadcaster()
+	about := Ticket{ID: 1}
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	b.Broadcast("ticket_updated", about, ticketDiff{ID: 1, Changes: map[string]interface{}{"status": "in_progress"}, UpdatedAt: at})
+	b.Broadcast("ticket_updated", about, ticketDiff{ID: 1, Changes: map[string]interface{}{"priority": "high"}, UpdatedAt: at})
+	b.Broadcast("ticket_updated", about, ticketDiff{ID: 1, Changes: map[string]interface{}{"room": "B2"}, UpdatedAt: at})
+	// another event about the ticket sends the held update first
+	b.Broadcast("ticket_deleted", about, map[string]int{"id": 1})
+	got := sentEvents(t, b)
+	want := []string{
+		`ticket_updated {"id":1,"changes":{"status":"in_progress"},"updated_at":"2024-05-01T10:00:00Z"}`,
+		`ticket_updated {"id":1,"changes":{"priority":"high","room":"B2"},"updated_at":"2024-05-01T10:00:00Z"}`,
+		`ticket_deleted {"id":1}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("sent:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWsConnectionLimit(t *testing.T) {
+	openTestDB(t)
+	prev := maxWsConnections
+	t.Cleanup(func() { maxWsConnections = prev })
+	maxWsConnections = 2
+	useBroadcaster(t)
+	srv := httptest.NewServer(http.HandlerFunc(adminWsHandler))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/admin?token=" + testToken(t, "viewer")
+
+	var open []*websocket.Conn
+	for i := 0; i < maxWsConnections; i++ {
+		c, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i+1, err)
+		}
+		t.Cleanup(func() { c.Close() })
+		open = append(open, c)
+		waitConnections(t, broad, i+1)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("connection over the limit: %v, %v", resp, err)
+	}
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code != "too_many_connections" {
+		t.Errorf("refusal body %+v, %v", body, err)
+	}
+
+	// a closed connection frees its place
+	open[0].Close()
+	waitConnections(t, broad, maxWsConnections-1)
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("connection after one closed: %v", err)
+	}
+	c.Close()
+}
+
+func TestAddRefusesOverLimit(t *testing.T) {
+	prev := maxWsConnections
+	t.Cleanup(func() { maxWsConnections = prev })
+	maxWsConnections = 1
+	b := NewBroadcaster()
+	dialBroadcaster(t, b)
+	if !b.WsFull() {
+		t.Fatal("one connection open with a limit of 1, but not full")
+	}
+
+	// a second upgrade that got past WsFull is still turned away by Add
+	up := websocket.Upgrader{}
+	added := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ok := b.Add(c, wsFilter{}, "")
+		if !ok {
+			c.Close()
+		}
+		added <- ok
+	}))
+	t.Cleanup(srv.Close)
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if <-added {
+		t.Error("Add registered a connection over the limit")
+	}
+	if n := b.Count(); n != 1 {
+		t.Errorf("%d connections registered, want 1", n)
+	}
+}
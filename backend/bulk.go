@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	maxBulkIDs      = 500
+	maxBatchTickets = 1000
+)
+
+// bulkStatusHandler serves PATCH /api/tickets/bulk with {"ids":[1,2,3],"status":"closed","status_reason":"..."},
+// updating every listed ticket in one transaction
+func bulkStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	var req struct {
+		IDs          []TicketID `json:"ids"`
+		Status       string     `json:"status"`
+		StatusReason *string    `json:"status_reason"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "ids is required")
+		return
+	}
+	if len(req.IDs) > maxBulkIDs {
+		writeError(w, http.StatusBadRequest, "invalid_request", "too many ids (max 500)")
+		return
+	}
+	if !slices.Contains(allowedStatuses, req.Status) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "invalid status (allowed: "+strings.Join(allowedStatuses, ", ")+")")
+		return
+	}
+	slices.Sort(req.IDs)
+	ids := slices.Compact(req.IDs)
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	in := "id IN (" + placeholders(len(ids)) + ") AND deleted_at IS NULL"
+	actor := actorFromRequest(r)
+	var before, tickets []Ticket
+	var affected int64
+	var refused error // a ticket that cannot take the new status
+	reasons := map[TicketID]string{}
+	err := inTx(ctx, func(tx *timedTx) error {
+		var err error
+		before, err = queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+in+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		for _, t := range before {
+			if err := checkTransition(t.Status, req.Status); err != nil {
+				refused = fmt.Errorf("ticket %d: %v", t.ID, err)
+				return refused
+			}
+			if reasons[t.ID], err = checkStatusReason(t.Status, req.Status, req.StatusReason); err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, "UPDATE tickets SET "+resolvedAtSet()+", status = ?, version = version + 1 WHERE "+in,
+			append([]interface{}{req.Status, req.Status, req.Status}, args...)...)
+		if err != nil {
+			return err
+		}
+		affected, _ = res.RowsAffected()
+		if tickets, err = queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+in+" ORDER BY id", args...); err != nil {
+			return err
+		}
+		for i := range tickets {
+			if err := writeAuditReason(ctx, tx, tickets[i].ID, "bulk_update", actor, reasons[tickets[i].ID], &before[i], &tickets[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if refused != nil && err == refused {
+		writeError(w, http.StatusConflict, "invalid_transition", refused.Error())
+		return
+	}
+	var fieldErrs FieldErrors
+	if errors.As(err, &fieldErrs) {
+		writeFieldErrors(w, fieldErrs)
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	found := map[TicketID]bool{}
+	for _, t := range tickets {
+		found[t.ID] = true
+	}
+	notFound := []TicketID{}
+	for _, id := range ids {
+		if !found[id] {
+			notFound = append(notFound, id)
+		}
+	}
+	ticketsUpdated.Add(float64(affected))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"updated": affected, "not_found": notFound})
+	for i, t := range tickets {
+		broad.Broadcast("ticket_updated", t, updatedPayload(before[i], t, reasons[t.ID]))
+	}
+}
+
+// batchCreateHandler serves POST /api/tickets/batch with a JSON array of tickets, e.g. a spreadsheet
+// import. Every ticket is checked like POST /api/tickets, except for the per-phone limit, and either
+// all are created in one transaction or, when any is invalid, none is and the 422 lists the problems
+// by array index. An import announces itself with one tickets_imported event instead of a
+// ticket_created per row, and sends no alerts.
+func batchCreateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	var in []Ticket
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if len(in) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "at least one ticket is required")
+		return
+	}
+	if len(in) > maxBatchTickets {
+		writeError(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("too many tickets (max %d)", maxBatchTickets))
+		return
+	}
+	tags := make([][]string, len(in))
+	var invalid []itemErrors
+	for i := range in {
+		var errs FieldErrors
+		var err error
+		if tags[i], errs, err = normalizeNewTicket(ctx, &in[i]); err != nil {
+			dbError(w, err)
+			return
+		}
+		if errs != nil {
+			invalid = append(invalid, itemErrors{Index: i, Fields: errs})
+		}
+	}
+	if len(invalid) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": apiError{
+			Code:    "validation_failed",
+			Message: fmt.Sprintf("%d of %d tickets are invalid, nothing was imported", len(invalid), len(in)),
+			Items:   invalid,
+		}})
+		return
+	}
+
+	actor := actorFromRequest(r)
+	var created []Ticket
+	err := inTx(ctx, func(tx *timedTx) error {
+		created = make([]Ticket, 0, len(in)) // a retried transaction starts over
+		for i, t := range in {
+			t, err := insertTicket(ctx, tx, t, tags[i], actor)
+			if err != nil {
+				return fmt.Errorf("ticket %d: %w", i, err)
+			}
+			created = append(created, t)
+		}
+		return nil
+	})
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+	ticketsCreated.Add(float64(len(created)))
+	ids := make([]TicketID, len(created))
+	for i, t := range created {
+		ids[i] = t.ID
+	}
+	log.Printf("imported %d tickets (ids %d-%d) by %s", len(created), ids[0], ids[len(ids)-1], actor)
+	broad.Broadcast("tickets_imported", Ticket{}, map[string]interface{}{"count": len(created), "ids": ids})
+}
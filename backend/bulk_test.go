@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// batchTicket is one valid element of a batch import
+func batchTicket(room string) string {
+	return fmt.Sprintf(`{"name":"Budi","phone":"08123456789","room":%q,"description":"dari spreadsheet"}`, room)
+}
+
+// countTickets is how many tickets are stored
+func countTickets(t *testing.T) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM tickets").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBatchCreate(t *testing.T) {
+	openTestDB(t)
+	w := serve(batchCreateHandler, "POST", "/api/tickets/batch", "["+batchTicket("A1")+","+batchTicket("B2")+"]")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	var created []Ticket
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 2 || created[0].ID == 0 || created[1].Room != "B2" {
+		t.Fatalf("created %+v", created)
+	}
+	if n := countTickets(t); n != 2 {
+		t.Fatalf("%d tickets stored, want 2", n)
+	}
+}
+
+func TestBatchCreateInvalidItemInsertsNothing(t *testing.T) {
+	openTestDB(t)
+	body := "[" + batchTicket("A1") + `,{"name":"","phone":"123","room":"A1"},` + batchTicket("B2") + `,{"name":"Sari","phone":"08123456789","room":"A1","priority":"bogus"}]`
+	w := serve(batchCreateHandler, "POST", "/api/tickets/batch", body)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("%d %s, want 422", w.Code, w.Body)
+	}
+	var res struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Error.Items) != 2 || res.Error.Items[0].Index != 1 || res.Error.Items[1].Index != 3 {
+		t.Fatalf("items %+v, want indexes 1 and 3", res.Error.Items)
+	}
+	if f := res.Error.Items[0].Fields; f["name"] == "" || f["phone"] == "" {
+		t.Errorf("item 1 fields %v, want name and phone", f)
+	}
+	if f := res.Error.Items[1].Fields; f["priority"] == "" {
+		t.Errorf("item 3 fields %v, want priority", f)
+	}
+	if n := countTickets(t); n != 0 {
+		t.Errorf("%d tickets stored, want none", n)
+	}
+}
+
+func TestBatchCreateFailedInsertRollsBack(t *testing.T) {
+	openTestDB(t)
+	// valid for every check, but the third insert fails inside the transaction
+	_, err := db.ExecContext(context.Background(), "CREATE TRIGGER fail_import BEFORE INSERT ON tickets WHEN NEW.room = 'boom' BEGIN SELECT RAISE(ABORT, 'boom'); END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := "[" + batchTicket("A1") + "," + batchTicket("B2") + "," + batchTicket("boom") + "]"
+	if w := serve(batchCreateHandler, "POST", "/api/tickets/batch", body); w.Code != http.StatusInternalServerError {
+		t.Fatalf("%d %s, want 500", w.Code, w.Body)
+	}
+	if n := countTickets(t); n != 0 {
+		t.Errorf("%d tickets kept from a failed import, want none", n)
+	}
+	var audits int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM audit_log").Scan(&audits); err != nil {
+		t.Fatal(err)
+	}
+	if audits != 0 {
+		t.Errorf("%d history entries kept from a failed import, want none", audits)
+	}
+}
+
+func TestBatchCreateLimits(t *testing.T) {
+	openTestDB(t)
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(batchTicket("A1")+",", maxBatchTickets+1), ",") + "]"
+	for _, body := range []string{"[]", tooMany} {
+		if w := serve(batchCreateHandler, "POST", "/api/tickets/batch", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%d tickets: %d, want 400", strings.Count(body, "{"), w.Code)
+		}
+	}
+}
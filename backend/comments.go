@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxCommentLen = 2000
+
+// Comment is one message in a ticket's support thread
+type Comment struct {
+	ID        int       `json:"id"`
+	TicketID  TicketID  `json:"ticket_id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ticketExists reports whether id is a ticket that hasn't been soft-deleted
+func ticketExists(ctx context.Context, id TicketID) (bool, error) {
+	var one int
+	err := db.QueryRowContext(ctx, "SELECT 1 FROM tickets WHERE id = ? AND deleted_at IS NULL", id).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// listCommentsHandler serves GET /api/tickets/{id}/comments in chronological order
+func listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	if ok, err := ticketExists(ctx, id); err != nil {
+		dbError(w, err)
+		return
+	} else if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	}
+	res, err := queryComments(ctx, db, id)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// queryComments returns the comments of a ticket in chronological order (never nil)
+func queryComments(ctx context.Context, q querier, ticketID TicketID) ([]Comment, error) {
+	rows, err := q.QueryContext(ctx, "SELECT id, ticket_id, author, body, created_at FROM comments WHERE ticket_id = ? ORDER BY created_at, id", ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []Comment{}
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.TicketID, &c.Author, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		inDisplayZone(&c.CreatedAt)
+		res = append(res, c)
+	}
+	return res, rows.Err()
+}
+
+// createCommentHandler serves POST /api/tickets/{id}/comments with {"author":"...","body":"..."}.
+// The author defaults to the token subject when omitted.
+func createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var c Comment
+	if !decodeJSON(w, r, &c) {
+		return
+	}
+	c.TicketID = id
+	c.Author = strings.TrimSpace(c.Author)
+	if claims, ok := claimsFromContext(r.Context()); ok && c.Author == "" {
+		c.Author = claims.Subject
+	}
+	errs := FieldErrors{}
+	if c.Author == "" {
+		errs["author"] = "is required"
+	}
+	if strings.TrimSpace(c.Body) == "" {
+		errs["body"] = "is required"
+	} else if utf8.RuneCountInString(c.Body) > maxCommentLen {
+		errs["body"] = "is too long"
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	// load the whole ticket: its priority and room decide which subscribed dashboards hear about the comment
+	t, err := loadTicket(ctx, db, id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	} else if err != nil {
+		dbError(w, err)
+		return
+	}
+	var res sql.Result
+	err = withRetry(ctx, func() error {
+		res, err = db.ExecContext(ctx, "INSERT INTO comments (ticket_id, author, body) VALUES (?, ?, ?)", c.TicketID, c.Author, c.Body)
+		return err
+	})
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	cid, _ := res.LastInsertId()
+	c.ID = int(cid)
+	_ = db.QueryRowContext(ctx, "SELECT created_at FROM comments WHERE id = ?", cid).Scan(&c.CreatedAt)
+	inDisplayZone(&c.CreatedAt)
+
+	writeJSON(w, http.StatusCreated, c)
+	broad.Broadcast("comment_added", t, map[string]interface{}{"ticket_id": id, "comment": c})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxImportBytes caps the CSV of POST /api/tickets/import.csv
+	maxImportBytes = 10 << 20
+	// maxImportRows is how many data rows one import may have; the rest are reported, not read
+	maxImportRows = 5000
+)
+
+// csvColumns are the header names POST /api/tickets/import.csv understands, in any order;
+// tags are comma separated within their cell
+var csvColumns = []string{"name", "phone", "room", "description", "anonymous", "status", "priority", "assigned_to", "tags", "lat", "lng"}
+
+// csvRequired are the columns every import must have
+var csvRequired = []string{"name", "phone", "room", "description"}
+
+// importRowError is a CSV row that was not imported; line is the row's line in the file
+type importRowError struct {
+	Line   int         `json:"line"`
+	Error  string      `json:"error"`
+	Fields FieldErrors `json:"fields,omitempty"`
+}
+
+// importSummary is the response of POST /api/tickets/import.csv
+type importSummary struct {
+	Inserted int              `json:"inserted"`
+	IDs      []TicketID       `json:"ids"`
+	Errors   []importRowError `json:"errors"`
+}
+
+// csvBody returns the CSV of the request: the "file" part of a multipart form, or the body itself
+// when it is sent as text/csv. Neither is read into memory.
+func csvBody(r *http.Request) (io.Reader, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "text/csv", "application/csv":
+		return r.Body, nil
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil, errors.New("file is required")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "file" {
+				return part, nil
+			}
+		}
+	}
+	return nil, errUnsupportedCSV
+}
+
+// errUnsupportedCSV is returned by csvBody for a request that is neither text/csv nor a multipart form
+var errUnsupportedCSV = errors.New("send the CSV as text/csv or as the file field of a multipart/form-data upload")
+
+// csvHeader maps each column of the header row to its index, checking the names
+func csvHeader(header []string) (map[string]int, error) {
+	cols := map[string]int{}
+	for i, h := range header {
+		// spreadsheet programs like to start the file with a byte order mark
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !slices.Contains(csvColumns, h) {
+			return nil, fmt.Errorf("unknown column %q (allowed: %s)", h, strings.Join(csvColumns, ", "))
+		}
+		if _, dup := cols[h]; dup {
+			return nil, fmt.Errorf("column %q appears twice", h)
+		}
+		cols[h] = i
+	}
+	for _, c := range csvRequired {
+		if _, ok := cols[c]; !ok {
+			return nil, fmt.Errorf("missing column %q", c)
+		}
+	}
+	return cols, nil
+}
+
+// ticketFromCSV builds the ticket of one data row; FieldErrors covers cells that do not parse
+func ticketFromCSV(cols map[string]int, rec []string) (Ticket, FieldErrors) {
+	cell := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	t := Ticket{Name: cell("name"), Phone: cell("phone"), Room: cell("room"), Description: cell("description"),
+		Status: cell("status"), Priority: strings.ToLower(cell("priority"))}
+	errs := FieldErrors{}
+	if v := cell("anonymous"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs["anonymous"] = "must be true or false"
+		}
+		t.Anonymous = b
+	}
+	if v := cell("assigned_to"); v != "" {
+		t.AssignedTo = &v
+	}
+	if v := cell("tags"); v != "" {
+		t.Tags = strings.Split(v, ",")
+	}
+	for _, c := range []struct {
+		name string
+		dst  **float64
+	}{{"lat", &t.Lat}, {"lng", &t.Lng}} {
+		if v := cell(c.name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs[c.name] = "must be a number"
+				continue
+			}
+			*c.dst = &f
+		}
+	}
+	if len(errs) > 0 {
+		return t, errs
+	}
+	return t, nil
+}
+
+// rowError turns what is wrong with a row's fields into its error entry
+func rowError(line int, errs FieldErrors) importRowError {
+	return importRowError{Line: line, Error: strings.TrimPrefix(errs.Error(), "validation failed: "), Fields: errs}
+}
+
+// importCSVHandler serves POST /api/tickets/import.csv. Unlike POST /api/tickets/batch it is
+// lenient: each valid row is inserted in its own transaction and the invalid ones are reported
+// by line, so one bad row does not hold back the rest. The first row names the columns.
+func importCSVHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	body, err := csvBody(r)
+	if errors.Is(err, errUnsupportedCSV) {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	cr := csv.NewReader(body)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid_request", "the CSV is empty")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "header row: "+err.Error())
+		return
+	}
+	cols, err := csvHeader(header)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	actor := actorFromRequest(r)
+	res := importSummary{IDs: []TicketID{}, Errors: []importRowError{}}
+	for rows := 0; ; rows++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// the reader carries on with the next record, so only this row is lost
+			res.Errors = append(res.Errors, importRowError{Line: parseErr.StartLine, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			msg := "reading the CSV failed: " + err.Error()
+			if errors.As(err, &tooBig) {
+				msg = fmt.Sprintf("the CSV is over %d bytes", maxImportBytes)
+			}
+			res.Errors = append(res.Errors, importRowError{Line: line, Error: msg + "; this row and the ones after it were not imported"})
+			break
+		}
+		if rows == maxImportRows {
+			res.Errors = append(res.Errors, importRowError{Line: line, Error: fmt.Sprintf("more than %d rows; this row and the ones after it were not imported", maxImportRows)})
+			break
+		}
+		t, errs := ticketFromCSV(cols, rec)
+		if errs != nil {
+			res.Errors = append(res.Errors, rowError(line, errs))
+			continue
+		}
+		id, errs, err := importRow(r, t, actor)
+		switch {
+		case err != nil:
+			log.Printf("csv import line %d: %v", line, err)
+			res.Errors = append(res.Errors, importRowError{Line: line, Error: "could not be saved"})
+		case errs != nil:
+			res.Errors = append(res.Errors, rowError(line, errs))
+		default:
+			res.Inserted++
+			res.IDs = append(res.IDs, id)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, res)
+	if res.Inserted == 0 {
+		return
+	}
+	ticketsCreated.Add(float64(res.Inserted))
+	log.Printf("csv import by %s: %d tickets inserted, %d rows rejected", actor, res.Inserted, len(res.Errors))
+	broad.Broadcast("tickets_imported", Ticket{}, map[string]interface{}{"count": res.Inserted, "ids": res.IDs})
+}
+
+// importRow validates and inserts one row's ticket with its own time budget, so a long file is
+// not cut short by the timeout of a single request
+func importRow(r *http.Request, t Ticket, actor string) (TicketID, FieldErrors, error) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	tags, errs, err := normalizeNewTicket(ctx, &t)
+	if err != nil || errs != nil {
+		return 0, errs, err
+	}
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		t, err = insertTicket(ctx, tx, t, tags, actor)
+		return err
+	})
+	return t.ID, nil, err
+}
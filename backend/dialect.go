@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// dialect covers the SQL that differs between the supported databases (-db-driver).
+// Both use "?" placeholders, so queries only go through it for the functions below.
+type dialect interface {
+	// Now is the current timestamp
+	Now() string
+	// AddSeconds is the timestamp expr moved by a number of seconds bound to one placeholder
+	AddSeconds(expr string) string
+	// Today is the start of the current day
+	Today() string
+	// SecondsBetween is the number of seconds from timestamp a to b
+	SecondsBetween(a, b string) string
+	// JSONText is the value at the JSON path bound to one placeholder in column, as text
+	JSONText(column string) string
+	// InsertIgnore starts an INSERT that silently skips rows hitting a unique key
+	InsertIgnore() string
+	// IsDuplicateKey reports whether err is a unique key violation
+	IsDuplicateKey(err error) bool
+	// IsTransient reports driver specific errors that go away when retried
+	IsTransient(err error) bool
+	// DSN adjusts -dsn so the database clock and the timestamps read back are both UTC
+	DSN(dsn string) (string, error)
+	// Timestamp is t as a query argument that compares correctly with the stored timestamps
+	Timestamp(t time.Time) interface{}
+}
+
+// dialects maps -db-driver values to their dialect; the key is also the database/sql driver name
+var dialects = map[string]dialect{
+	"mysql":  mysqlDialect{},
+	"sqlite": sqliteDialect{},
+}
+
+// sqlDialect is the dialect of db
+var sqlDialect dialect = mysqlDialect{}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Now() string                   { return "NOW()" }
+func (mysqlDialect) AddSeconds(expr string) string { return "(" + expr + " + INTERVAL ? SECOND)" }
+func (mysqlDialect) Today() string                 { return "CURDATE()" }
+func (mysqlDialect) SecondsBetween(a, b string) string {
+	return "TIMESTAMPDIFF(SECOND, " + a + ", " + b + ")"
+}
+func (mysqlDialect) JSONText(column string) string {
+	return "JSON_UNQUOTE(JSON_EXTRACT(" + column + ", ?))"
+}
+func (mysqlDialect) InsertIgnore() string { return "INSERT IGNORE" }
+
+func (mysqlDialect) IsDuplicateKey(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && me.Number == 1062
+}
+
+// IsTransient matches lock wait timeouts (1205) and deadlocks (1213)
+func (mysqlDialect) IsTransient(err error) bool {
+	var me *mysql.MySQLError
+	return errors.As(err, &me) && (me.Number == 1205 || me.Number == 1213)
+}
+
+// DSN sets the session time_zone to UTC and parses DATETIME columns as UTC, so NOW()
+// and the values written from Go agree whatever zone the server runs in
+func (mysqlDialect) DSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	return cfg.FormatDSN(), nil
+}
+
+func (mysqlDialect) Timestamp(t time.Time) interface{} { return t.UTC() }
+
+// sqliteDialect is meant for local development. Use a DSN like
+// "file:ticketing.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate".
+type sqliteDialect struct{}
+
+func (sqliteDialect) Now() string { return "CURRENT_TIMESTAMP" }
+func (sqliteDialect) AddSeconds(expr string) string {
+	return "datetime(" + expr + ", ? || ' seconds')"
+}
+func (sqliteDialect) Today() string { return "date('now')" }
+func (sqliteDialect) SecondsBetween(a, b string) string {
+	return "CAST((julianday(" + b + ") - julianday(" + a + ")) * 86400 AS INTEGER)"
+}
+func (sqliteDialect) JSONText(column string) string {
+	return "CAST(json_extract(" + column + ", ?) AS TEXT)"
+}
+func (sqliteDialect) InsertIgnore() string { return "INSERT OR IGNORE" }
+
+func (sqliteDialect) IsDuplicateKey(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || se.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// IsTransient covers a locked database file that busy_timeout didn't wait out
+func (sqliteDialect) IsTransient(err error) bool {
+	var se *sqlite.Error
+	return errors.As(err, &se) && (se.Code()&0xff == sqlite3.SQLITE_BUSY || se.Code()&0xff == sqlite3.SQLITE_LOCKED)
+}
+
+// DSN is unchanged: CURRENT_TIMESTAMP is already UTC in SQLite
+func (sqliteDialect) DSN(dsn string) (string, error) { return dsn, nil }
+
+// Timestamp is the text CURRENT_TIMESTAMP stores. Timestamps are compared as text, and the
+// driver would add a zone offset, making a bound equal to a stored time look later than it.
+func (sqliteDialect) Timestamp(t time.Time) interface{} {
+	return t.UTC().Format("2006-01-02 15:04:05.999999999")
+}
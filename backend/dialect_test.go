@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestMySQLDSNIsUTC(t *testing.T) {
+	for _, dsn := range []string{
+		"root:@tcp(127.0.0.1:3306)/ticketing_db",
+		"root:@tcp(127.0.0.1:3306)/ticketing_db?parseTime=false&loc=Asia%2FJakarta&time_zone=%27%2B07%3A00%27",
+	} {
+		out, err := mysqlDialect{}.DSN(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := mysql.ParseDSN(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cfg.ParseTime || cfg.Loc != time.UTC || cfg.Params["time_zone"] != "'+00:00'" {
+			t.Errorf("%s: parseTime=%v loc=%s time_zone=%s, want true, UTC, '+00:00'", dsn, cfg.ParseTime, cfg.Loc, cfg.Params["time_zone"])
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"time"
+)
+
+// broadcastDiffs makes ticket_updated carry only the changed fields (-broadcast-diffs)
+var broadcastDiffs bool
+
+// ticketDiff is the ticket_updated payload with -broadcast-diffs: the fields that changed, by
+// their JSON name, with null for one that was cleared
+type ticketDiff struct {
+	ID        TicketID               `json:"id"`
+	Changes   map[string]interface{} `json:"changes"`
+	UpdatedAt time.Time              `json:"updated_at"`
+	Reason    string                 `json:"reason,omitempty"`
+}
+
+// then returns the diff of d followed by next, for coalesced updates
+func (d ticketDiff) then(next ticketDiff) ticketDiff {
+	changes := maps.Clone(d.Changes)
+	maps.Copy(changes, next.Changes)
+	next.Changes = changes
+	if next.Reason == "" {
+		next.Reason = d.Reason
+	}
+	return next
+}
+
+// ticketFields is t as the generic JSON object clients see
+func ticketFields(t Ticket) map[string]interface{} {
+	// a Ticket always encodes
+	b, _ := json.Marshal(t)
+	fields := map[string]interface{}{}
+	json.Unmarshal(b, &fields)
+	return fields
+}
+
+// diffTickets returns the fields of after that differ from before, except id and updated_at
+func diffTickets(before, after Ticket) map[string]interface{} {
+	old, cur := ticketFields(before), ticketFields(after)
+	changes := map[string]interface{}{}
+	for k, v := range cur {
+		if !reflect.DeepEqual(old[k], v) {
+			changes[k] = v
+		}
+	}
+	// omitempty drops a cleared field, e.g. assigned_to after unassigning
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			changes[k] = nil
+		}
+	}
+	delete(changes, "id")
+	delete(changes, "updated_at")
+	return changes
+}
+
+// updatedPayload is what ticket_updated carries for a change from before to after: the whole
+// ticket, or with -broadcast-diffs only what changed
+func updatedPayload(before, after Ticket, reason string) interface{} {
+	if !broadcastDiffs {
+		return ticketUpdate{after, reason}
+	}
+	return ticketDiff{ID: after.ID, Changes: diffTickets(before, after), UpdatedAt: after.UpdatedAt, Reason: reason}
+}
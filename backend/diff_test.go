@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffTickets(t *testing.T) {
+	agent, lat, lng := "sari", -6.2, 106.8
+	base := Ticket{ID: 5, Name: "Budi", Phone: "+628123456789", Room: "A1", Description: "AC bocor", Status: "open", Priority: "medium",
+		Version: 1, Tags: []string{"ac"}, CreatedAt: time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC), UpdatedAt: time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)}
+	for _, tc := range []struct {
+		name   string
+		change func(*Ticket)
+		want   map[string]interface{}
+	}{
+		{"nothing", func(*Ticket) {}, map[string]interface{}{}},
+		{"only updated_at", func(t *Ticket) { t.UpdatedAt = t.UpdatedAt.Add(time.Minute) }, map[string]interface{}{}},
+		{"status", func(t *Ticket) { t.Status, t.Version = "closed", 2 }, map[string]interface{}{"status": "closed", "version": float64(2)}},
+		{"assigned", func(t *Ticket) { t.AssignedTo = &agent }, map[string]interface{}{"assigned_to": "sari"}},
+		{"tags", func(t *Ticket) { t.Tags = []string{"ac", "lantai-2"} }, map[string]interface{}{"tags": []interface{}{"ac", "lantai-2"}}},
+		{"location", func(t *Ticket) { t.Lat, t.Lng = &lat, &lng }, map[string]interface{}{"lat": lat, "lng": lng}},
+		{"metadata", func(t *Ticket) { t.Metadata = map[string]interface{}{"assetTag": "INV-0042"} }, map[string]interface{}{"metadata": map[string]interface{}{"assetTag": "INV-0042"}}},
+	} {
+		after := base
+		tc.change(&after)
+		if got := diffTickets(base, after); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: diff %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// a field omitted once cleared shows up as null
+	assigned := base
+	assigned.AssignedTo, assigned.Tags = &agent, nil
+	got := diffTickets(assigned, base)
+	if want := map[string]interface{}{"assigned_to": nil, "tags": []interface{}{"ac"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unassigning: diff %v, want %v", got, want)
+	}
+	got = diffTickets(base, assigned)
+	if want := map[string]interface{}{"assigned_to": "sari", "tags": nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("removing the tags: diff %v, want %v", got, want)
+	}
+}
+
+func TestTicketDiffThen(t *testing.T) {
+	first := ticketDiff{ID: 5, Changes: map[string]interface{}{"status": "in_progress", "assigned_to": "sari"}, Reason: "diambil"}
+	second := ticketDiff{ID: 5, Changes: map[string]interface{}{"status": "resolved"}, UpdatedAt: time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)}
+	got := first.then(second)
+	want := ticketDiff{ID: 5, Changes: map[string]interface{}{"status": "resolved", "assigned_to": "sari"}, UpdatedAt: second.UpdatedAt, Reason: "diambil"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(first.Changes) != 2 || first.Changes["status"] != "in_progress" {
+		t.Errorf("then changed the earlier diff: %v", first.Changes)
+	}
+}
+
+func TestUpdatedPayload(t *testing.T) {
+	prev := broadcastDiffs
+	t.Cleanup(func() { broadcastDiffs = prev })
+	before := Ticket{ID: 5, Name: "Budi", Room: "A1", Status: "open", Priority: "medium", Version: 1}
+	after := before
+	after.Status, after.Version, after.UpdatedAt = "closed", 2, time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	broadcastDiffs = true
+	b, _ := json.Marshal(updatedPayload(before, after, ""))
+	if want := `{"id":5,"changes":{"status":"closed","version":2},"updated_at":"2026-10-01T09:00:00Z"}`; string(b) != want {
+		t.Errorf("-broadcast-diffs payload %s, want %s", b, want)
+	}
+
+	broadcastDiffs = false
+	var full map[string]interface{}
+	b, _ = json.Marshal(updatedPayload(before, after, "selesai"))
+	if err := json.Unmarshal(b, &full); err != nil {
+		t.Fatal(err)
+	}
+	if full["status"] != "closed" || full["name"] != "Budi" || full["reason"] != "selesai" || full["changes"] != nil {
+		t.Errorf("full payload %s", b)
+	}
+}
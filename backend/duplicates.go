@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// duplicateThreshold is the trigram similarity from which an open ticket counts as a possible duplicate
+	duplicateThreshold = 0.5
+	// duplicateScanLimit caps how many of the room's newest open tickets are compared
+	duplicateScanLimit = 200
+	// maxDuplicates is how many suggestions are returned, most similar first
+	maxDuplicates = 5
+)
+
+// duplicateCandidate is an open ticket suggested as a duplicate, with how similar its description is (0..1)
+type duplicateCandidate struct {
+	Ticket
+	Similarity float64 `json:"similarity"`
+}
+
+// hiddenDuplicate is what a caller without a token learns of a duplicateCandidate with
+// -public-ids-only: nothing that identifies the ticket or its reporter
+type hiddenDuplicate struct {
+	Room       string    `json:"room"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	Similarity float64   `json:"similarity"`
+}
+
+// trigrams returns the set of three-rune sequences of s, lowercased and with punctuation
+// folded into single spaces, so "Printer rusak!" and "printer  rusak" compare equal
+func trigrams(s string) map[string]bool {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	runes := []rune(" " + strings.Join(fields, " ") + " ")
+	set := map[string]bool{}
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
+
+// similarity is the Jaccard index of the trigram sets of a and b
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for g := range a {
+		if b[g] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// findDuplicates returns the open tickets in t's room whose description is similar to t's
+func findDuplicates(ctx context.Context, t Ticket) ([]duplicateCandidate, error) {
+	open, err := queryTickets(ctx, db, "SELECT "+ticketColumns+" FROM tickets WHERE room = ? AND status IN ('open', 'in_progress') AND deleted_at IS NULL ORDER BY created_at DESC, id DESC LIMIT ?",
+		t.Room, duplicateScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	want := trigrams(t.Description)
+	found := []duplicateCandidate{}
+	for _, o := range open {
+		if score := similarity(want, trigrams(o.Description)); score >= duplicateThreshold {
+			found = append(found, duplicateCandidate{o, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Similarity > found[j].Similarity })
+	if len(found) > maxDuplicates {
+		found = found[:maxDuplicates]
+	}
+	return found, nil
+}
+
+// answerDuplicates handles ?check_duplicates=true on POST /api/tickets: when similar open tickets
+// exist it answers 200 with them instead of creating t, unless ?force=true. It reports whether it
+// wrote a response.
+func answerDuplicates(ctx context.Context, w http.ResponseWriter, r *http.Request, t Ticket) bool {
+	q := r.URL.Query()
+	if q.Get("check_duplicates") != "true" || q.Get("force") == "true" {
+		return false
+	}
+	found, err := findDuplicates(ctx, t)
+	if err != nil {
+		dbError(w, err)
+		return true
+	}
+	if len(found) == 0 {
+		return false
+	}
+	if publicIDsOnly && !isAdmin(r) {
+		hidden := make([]hiddenDuplicate, len(found))
+		for i, d := range found {
+			hidden[i] = hiddenDuplicate{d.Room, d.Status, d.CreatedAt, d.Similarity}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"possible_duplicates": hidden})
+		return true
+	}
+	if !isAdmin(r) {
+		for i := range found {
+			publicView(&found[i].Ticket)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"possible_duplicates": found})
+	return true
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+// emailWebhookSecret signs inbound email webhooks (-email-webhook-secret). Empty disables the endpoint.
+var emailWebhookSecret []byte
+
+// emailSignatureHeader carries "sha256=<hex HMAC-SHA256 of the raw body>"
+const emailSignatureHeader = "X-Webhook-Signature"
+
+// inboundEmail is the part of the mail provider's parsed payload we use; other fields are ignored
+type inboundEmail struct {
+	From    string `json:"from"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// validEmailSignature compares the signature header with the HMAC of body in constant time
+func validEmailSignature(header string, body []byte) bool {
+	sig, err := hex.DecodeString(strings.TrimPrefix(header, "sha256="))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, emailWebhookSecret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// truncateRunes cuts s to at most n characters
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// emailTicketHandler serves POST /api/tickets/email, opening a ticket from an inbound email.
+// Emails carry no phone or room, so those stay empty and the sender goes into the name.
+func emailTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", "request body too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "invalid_request", "could not read body")
+		return
+	}
+	if !validEmailSignature(r.Header.Get(emailSignatureHeader), body) {
+		writeError(w, http.StatusUnauthorized, "invalid_signature", "invalid signature")
+		return
+	}
+	var in inboundEmail
+	if err := json.Unmarshal(body, &in); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "invalid json: "+err.Error())
+		return
+	}
+	addr, err := mail.ParseAddress(in.From)
+	if err != nil {
+		writeFieldErrors(w, FieldErrors{"from": "must be an email address"})
+		return
+	}
+	name := addr.Name
+	if name == "" {
+		name = addr.Address
+	}
+	desc := strings.TrimSpace(in.Subject)
+	if b := strings.TrimSpace(in.Body); b != "" {
+		desc += "\n\n" + b
+	}
+	sanitizeText(&name, &desc)
+	t := Ticket{
+		Name:        truncateRunes(name, maxNameLen),
+		Description: truncateRunes(desc, maxDescriptionLen),
+		Status:      "open",
+		Priority:    "medium",
+	}
+	input := t
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		t, err = insertTicket(ctx, tx, input, nil, "email:"+addr.Address)
+		return err
+	})
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	writeCreatedTicket(w, t)
+	announceCreated(t)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// unknownEnum stands in for a status or priority outside allowedStatuses/allowedPriorities,
+// so rows written around the API are still listed instead of breaking serialization
+const unknownEnum = "unknown"
+
+// maxReportedDirty is how many offending ticket ids checkEnumValues logs
+const maxReportedDirty = 20
+
+// knownOr returns v when it is one of allowed, unknownEnum otherwise
+func knownOr(allowed []string, v string) string {
+	if slices.Contains(allowed, v) {
+		return v
+	}
+	return unknownEnum
+}
+
+// quotedList renders values as a SQL list of string literals; only for the constant enums
+func quotedList(values []string) string {
+	return "'" + strings.Join(values, "', '") + "'"
+}
+
+// enumViolation is the WHERE condition matching tickets with a status or priority outside the enums
+func enumViolation() string {
+	return "status NOT IN (" + quotedList(allowedStatuses) + ") OR priority NOT IN (" + quotedList(allowedPriorities) + ")"
+}
+
+// checkEnumValues logs a warning for tickets whose status or priority is not an allowed value,
+// e.g. after a direct DB write
+func checkEnumValues(ctx context.Context) error {
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tickets WHERE "+enumViolation()).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id, status, priority FROM tickets WHERE "+enumViolation()+" ORDER BY id LIMIT ?", maxReportedDirty)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var found []string
+	for rows.Next() {
+		var id int
+		var status, priority string
+		if err := rows.Scan(&id, &status, &priority); err != nil {
+			return err
+		}
+		found = append(found, fmt.Sprintf("#%d (status %q, priority %q)", id, status, priority))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	log.Printf("warning: %d tickets have a status or priority outside the allowed values, they are served as %q until fixed: %s",
+		n, unknownEnum, strings.Join(found, ", "))
+	return nil
+}
+
+// enforceEnumConstraints adds CHECK constraints for status and priority (-enforce-enum-constraints).
+// MySQL only enforces them from 8.0.16; the SQLite schema has had them from the start.
+func enforceEnumConstraints(ctx context.Context, driver string) error {
+	if driver != "mysql" {
+		log.Printf("enum constraints: already part of the %s schema", driver)
+		return nil
+	}
+	existing := map[string]bool{}
+	rows, err := db.QueryContext(ctx, `SELECT CONSTRAINT_NAME FROM information_schema.TABLE_CONSTRAINTS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'tickets' AND CONSTRAINT_TYPE = 'CHECK'`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		name, column string
+		values       []string
+	}{
+		{"chk_tickets_status", "status", allowedStatuses},
+		{"chk_tickets_priority", "priority", allowedPriorities},
+	} {
+		if existing[c.name] {
+			continue
+		}
+		q := "ALTER TABLE tickets ADD CONSTRAINT " + c.name + " CHECK (" + c.column + " IN (" + quotedList(c.values) + "))"
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("%s: %w (fix the tickets reported above first)", c.name, err)
+		}
+		log.Printf("enum constraints: added %s", c.name)
+	}
+	return nil
+}
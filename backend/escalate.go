@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// escalationRule maps a priority to the one an overdue, unassigned ticket is raised to (-escalation-rule).
+// critical has no entry, so escalation stops there.
+var escalationRule = map[string]string{"low": "medium", "medium": "high", "high": "urgent", "urgent": "critical"}
+
+// parseEscalationRule parses "low:medium,medium:high" into the rule. Every step must raise the
+// priority, so no chain of steps can loop.
+func parseEscalationRule(raw string) (map[string]string, error) {
+	rule := map[string]string{}
+	for _, step := range strings.Split(raw, ",") {
+		if step = strings.TrimSpace(step); step == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(step, ":")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		i, j := slices.Index(allowedPriorities, from), slices.Index(allowedPriorities, to)
+		switch {
+		case !ok || i < 0 || j < 0:
+			return nil, fmt.Errorf("invalid step %q (want from:to, priorities %s)", step, strings.Join(allowedPriorities, ", "))
+		case j <= i:
+			return nil, fmt.Errorf("step %q does not raise the priority", step)
+		case rule[from] != "":
+			return nil, fmt.Errorf("%s is escalated twice", from)
+		}
+		rule[from] = to
+	}
+	return rule, nil
+}
+
+// watchEscalation raises overdue, unassigned tickets one step of escalationRule every interval
+func watchEscalation(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		n, err := escalateOverdue(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("escalation: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("escalation: raised the priority of %d overdue unassigned tickets", n)
+		}
+	}
+}
+
+// escalateOverdue raises each overdue, unassigned, unresolved ticket one step and gives it the
+// deadline of its new priority, counted from now, so it is only raised again if that passes too.
+// Every step gets an "escalate" audit entry and a ticket_updated broadcast.
+func escalateOverdue(ctx context.Context) (int, error) {
+	if len(escalationRule) == 0 {
+		return 0, nil
+	}
+	from := make([]interface{}, 0, len(escalationRule))
+	for p := range escalationRule {
+		from = append(from, p)
+	}
+	var before, raised []Ticket
+	var reasons []string
+	err := inTx(ctx, func(tx *timedTx) error {
+		before, raised, reasons = nil, nil, nil
+		due, err := queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+overdueCond()+
+			" AND assigned_to IS NULL AND priority IN ("+placeholders(len(from))+") ORDER BY id", from...)
+		if err != nil {
+			return err
+		}
+		for i := range due {
+			b := due[i]
+			to := escalationRule[b.Priority]
+			// the version check leaves a ticket alone that was edited since the SELECT
+			res, err := tx.ExecContext(ctx, "UPDATE tickets SET priority = ?, due_at = "+sqlDialect.AddSeconds(sqlDialect.Now())+
+				", overdue_notified_at = NULL, version = version + 1 WHERE id = ? AND version = ?",
+				to, int(slaDurations[to].Seconds()), b.ID, b.Version)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue
+			}
+			t, err := loadTicket(ctx, tx, b.ID)
+			if err != nil {
+				return err
+			}
+			reason := fmt.Sprintf("overdue and unassigned, priority raised from %s to %s", b.Priority, to)
+			if err := writeAuditReason(ctx, tx, t.ID, "escalate", "system", reason, &b, &t); err != nil {
+				return err
+			}
+			before, raised, reasons = append(before, b), append(raised, t), append(reasons, reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	ticketsUpdated.Add(float64(len(raised)))
+	for i, t := range raised {
+		broad.Broadcast("ticket_updated", t, updatedPayload(before[i], t, reasons[i]))
+		escalateIfCritical(&before[i], t)
+	}
+	return len(raised), nil
+}
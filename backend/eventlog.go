@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+)
+
+// eventPruneEvery is how many broadcasts pass between deletions of rows older than the replay window
+const eventPruneEvery = 100
+
+// unsavedEvent is a broadcast waiting for storeEvents
+type unsavedEvent struct {
+	event string
+	about Ticket
+	raw   []byte
+}
+
+// restoreEvents continues the broadcast sequence from the broadcast_events table and reloads the
+// replay window from it, so the seq a dashboard resyncs from, or an EventSource's Last-Event-ID,
+// stays valid across restarts. From then on every broadcast is stored, and numbered by the
+// database, before it is sent. With an empty table the sequence restarts from the database's
+// counter, so a client resyncing from an older number is sent a fresh init.
+func (b *Broadcaster) restoreEvents(ctx context.Context) error {
+	rows, err := db.QueryContext(ctx, "SELECT seq, event, ticket_id, room, priority, payload FROM broadcast_events ORDER BY seq DESC LIMIT ?", replayWindow)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var recent []sentEvent
+	for rows.Next() {
+		var e sentEvent
+		var event, payload string
+		if err := rows.Scan(&e.seq, &event, &e.about.ID, &e.about.Room, &e.about.Priority, &payload); err != nil {
+			return err
+		}
+		if e.data, err = encodeSeqEvent(event, e.about, json.RawMessage(payload), e.seq); err != nil {
+			return err
+		}
+		recent = append(recent, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	slices.Reverse(recent)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq, b.recent = 0, recent
+	if len(recent) > 0 {
+		b.seq = recent[len(recent)-1].seq
+	}
+	b.persist = true
+	go b.storeEvents()
+	log.Printf("broadcast events: continuing from seq %d, %d kept for replay", b.seq, len(recent))
+	return nil
+}
+
+// encodeSeqEvent is the message of a numbered broadcast, with the notify hint of about when
+// the event has one, and the ack_id clients acknowledge a ticket_critical with. Seq 0 is a
+// broadcast that could not be stored; it has neither seq nor ack_id.
+func encodeSeqEvent(event string, about Ticket, payload json.RawMessage, seq uint64) ([]byte, error) {
+	m := map[string]interface{}{"event": event, "payload": payload}
+	if hint := notifyHint(event, about); hint != "" {
+		m["notify"] = hint
+	}
+	if seq > 0 {
+		m["seq"] = seq
+		if event == "ticket_critical" {
+			m["ack_id"] = ackIDFor(seq)
+		}
+	}
+	return json.Marshal(m)
+}
+
+// storeEvents writes the broadcasts waiting in unsaved, oldest first, and sends each one as
+// soon as it is stored. It is the only writer, so the broadcasts keep their order, and it runs
+// without b.mu, so a slow database delays the broadcasts but never the Broadcaster.
+func (b *Broadcaster) storeEvents() {
+	for range b.wake {
+		b.mu.Lock()
+		batch := b.unsaved
+		b.unsaved, b.storing = nil, true
+		b.mu.Unlock()
+		for _, e := range batch {
+			seq := storeEvent(e)
+			b.mu.Lock()
+			b.deliver(e.event, e.about, e.raw, seq)
+			b.mu.Unlock()
+		}
+		b.mu.Lock()
+		b.storing = false
+		b.stored.Broadcast()
+		b.mu.Unlock()
+	}
+}
+
+// waitStored returns once every broadcast handed to storeEvents so far has been sent; b.mu must
+// be held, and is released while waiting
+func (b *Broadcaster) waitStored() {
+	for b.persist && (len(b.unsaved) > 0 || b.storing) {
+		b.stored.Wait()
+	}
+}
+
+// storeEvent writes one broadcast and returns the seq the database numbered it with, which no
+// other instance sharing the database can get too. It returns 0 when the write fails: the event
+// then goes out without a seq, and cannot be replayed.
+func storeEvent(e unsavedEvent) uint64 {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	res, err := db.ExecContext(ctx, "INSERT INTO broadcast_events (event, ticket_id, room, priority, payload) VALUES (?, ?, ?, ?, ?)",
+		e.event, e.about.ID, e.about.Room, e.about.Priority, string(e.raw))
+	var id int64
+	if err == nil {
+		id, err = res.LastInsertId()
+	}
+	if err != nil {
+		log.Printf("broadcast events: storing %s: %v; sent without a seq", e.event, err)
+		eventStoreFailures.Inc()
+		return 0
+	}
+	seq := uint64(id)
+	if seq%eventPruneEvery == 0 && seq > replayWindow {
+		if _, err := db.ExecContext(ctx, "DELETE FROM broadcast_events WHERE seq <= ?", seq-replayWindow); err != nil {
+			log.Printf("broadcast events: pruning: %v", err)
+		}
+	}
+	return seq
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// restoredBroadcaster is a Broadcaster persisting to the test database, as after startup
+func restoredBroadcaster(t *testing.T) *Broadcaster {
+	t.Helper()
+	b := NewBroadcaster()
+	if err := b.restoreEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// settle waits until b has stored and sent everything broadcast so far and returns its seq
+func settle(b *Broadcaster) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.waitStored()
+	return b.seq
+}
+
+func TestBroadcastSeqSurvivesRestart(t *testing.T) {
+	openTestDB(t)
+	first := restoredBroadcaster(t)
+	for i := 1; i <= 3; i++ {
+		first.Broadcast("ticket_created", Ticket{ID: TicketID(i), Room: "A1"}, map[string]int{"id": i})
+	}
+	last := settle(first)
+	if last == 0 {
+		t.Fatal("no seq after three broadcasts")
+	}
+
+	second := restoredBroadcaster(t)
+	if got := second.Seq(); got != last {
+		t.Fatalf("seq after restart = %d, want %d", got, last)
+	}
+	if len(second.recent) != len(first.recent) {
+		t.Fatalf("%d events restored for replay, want %d", len(second.recent), len(first.recent))
+	}
+	for i, e := range second.recent {
+		if want := first.recent[i]; e.seq != want.seq || !bytes.Equal(e.data, want.data) {
+			t.Errorf("restored event %d = #%d %s, want #%d %s", i, e.seq, e.data, want.seq, want.data)
+		}
+	}
+	second.Broadcast("ticket_created", Ticket{ID: 4}, map[string]int{"id": 4})
+	if got := settle(second); got != last+1 {
+		t.Fatalf("first seq after restart = %d, want %d", got, last+1)
+	}
+}
+
+func TestBroadcastSeqNotReusedAfterDelete(t *testing.T) {
+	openTestDB(t)
+	b := restoredBroadcaster(t)
+	b.Broadcast("ticket_created", Ticket{ID: 1}, nil)
+	b.Broadcast("ticket_created", Ticket{ID: 2}, nil)
+	last := settle(b)
+	// retention deletes the stored events of purged tickets, the newest included
+	if _, err := db.ExecContext(context.Background(), "DELETE FROM broadcast_events WHERE ticket_id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	b = restoredBroadcaster(t)
+	b.Broadcast("ticket_created", Ticket{ID: 3}, nil)
+	if got := settle(b); got <= last {
+		t.Fatalf("seq after deleting #%d = %d, want a new number", last, got)
+	}
+}
+
+func TestBroadcastSeqUniqueAcrossInstances(t *testing.T) {
+	openTestDB(t)
+	instances := []*Broadcaster{restoredBroadcaster(t), restoredBroadcaster(t)}
+	const each = 20
+	var wg sync.WaitGroup
+	for _, b := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= each; i++ {
+				b.Broadcast("ticket_created", Ticket{ID: TicketID(i)}, nil)
+			}
+		}()
+	}
+	wg.Wait()
+	seen := map[uint64]bool{}
+	for _, b := range instances {
+		settle(b)
+		prev := uint64(0)
+		for _, e := range b.recent {
+			if e.seq <= prev {
+				t.Errorf("seq %d sent after %d", e.seq, prev)
+			}
+			if seen[e.seq] {
+				t.Errorf("seq %d handed out twice", e.seq)
+			}
+			seen[e.seq], prev = true, e.seq
+		}
+	}
+	if len(seen) != 2*each {
+		t.Fatalf("%d numbered broadcasts, want %d", len(seen), 2*each)
+	}
+}
+
+func TestEncodeSeqEventUnstored(t *testing.T) {
+	data, err := encodeSeqEvent("ticket_critical", Ticket{ID: 1, Priority: "critical"}, json.RawMessage(`{}`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"seq", "ack_id"} {
+		if _, ok := m[key]; ok {
+			t.Errorf("unstored broadcast has %q: %s", key, data)
+		}
+	}
+}
+
+func TestShutdownSendsStoredEventsFirst(t *testing.T) {
+	openTestDB(t)
+	b := restoredBroadcaster(t)
+	c := dialBroadcaster(t, b)
+	for i := 1; i <= 3; i++ {
+		b.Broadcast("ticket_created", Ticket{ID: TicketID(i), Room: "A1"}, map[string]int{"id": i})
+	}
+	// straight away, while the events may still be waiting to be stored
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	b.Shutdown(ctx)
+
+	var got []string
+	var last uint64
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var m struct {
+			Event string `json:"event"`
+			Seq   uint64 `json:"seq"`
+		}
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		got = append(got, m.Event)
+		if m.Event == "server_shutdown" {
+			break
+		}
+		if m.Seq <= last {
+			t.Errorf("%s has seq %d after %d", m.Event, m.Seq, last)
+		}
+		last = m.Seq
+	}
+	if want := []string{"ticket_created", "ticket_created", "ticket_created", "server_shutdown"}; !slices.Equal(got, want) {
+		t.Errorf("client got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// feedSize is how many of the newest tickets GET /api/tickets/feed.xml lists (-feed-size, 1-200)
+var feedSize = 20
+
+// atomFeed and atomEntry are the parts of RFC 4287 the ticket feed uses
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated time.Time   `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Published time.Time `xml:"published"`
+	Updated   time.Time `xml:"updated"`
+	Author    string    `xml:"author>name"`
+	Link      atomLink  `xml:"link"`
+	Content   atomText  `xml:"content"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// requestOrigin is scheme://host of the request, for the absolute links a feed needs
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedHandler serves GET /api/tickets/feed.xml: an Atom feed of the newest tickets, filtered
+// like GET /api/tickets, e.g. ?priority=high. Feed readers rarely send a token, so reporters
+// of anonymous tickets are hidden unless the caller is an admin.
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTicketFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	tickets, err := queryTickets(ctx, readDB(), "SELECT "+ticketColumns+" FROM tickets"+filter.where()+" ORDER BY created_at DESC, id DESC LIMIT ?", append(filter.args, feedSize)...)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	origin := requestOrigin(r)
+	self := origin + r.URL.RequestURI()
+	feed := atomFeed{ID: self, Title: "Tiket terbaru", Link: atomLink{Rel: "self", Href: self}, Entries: []atomEntry{}}
+	for _, t := range tickets {
+		if !isAdmin(r) {
+			publicView(&t)
+		}
+		link := fmt.Sprintf("%s/api/tickets/%d", origin, t.ID)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:        link,
+			Title:     fmt.Sprintf("#%d %s (%s)", t.ID, t.Name, t.Room),
+			Published: t.CreatedAt,
+			Updated:   t.UpdatedAt,
+			Author:    t.Name,
+			Link:      atomLink{Href: link},
+			Content:   atomText{Type: "text", Body: t.Description},
+		})
+		if t.UpdatedAt.After(feed.Updated) {
+			feed.Updated = t.UpdatedAt
+		}
+	}
+	// an empty feed still needs an updated date
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now().In(displayLoc)
+	}
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/atom+xml; charset=utf-8")
+	h.Set("Cache-Control", "private, no-cache")
+	h.Add("Vary", "Authorization")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
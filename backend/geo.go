@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseBBox parses "minLng,minLat,maxLng,maxLat". minLng may exceed maxLng for a box
+// crossing the antimeridian.
+func parseBBox(raw string) (minLng, minLat, maxLng, maxLat float64, err error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) != 4 {
+		return 0, 0, 0, 0, fmt.Errorf("bbox must be minLng,minLat,maxLng,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("invalid bbox number %q", p)
+		}
+	}
+	minLng, minLat, maxLng, maxLat = v[0], v[1], v[2], v[3]
+	if len(validateLocation(&minLat, &minLng)) > 0 || len(validateLocation(&maxLat, &maxLng)) > 0 {
+		return 0, 0, 0, 0, fmt.Errorf("bbox latitudes must be within -90..90 and longitudes within -180..180")
+	}
+	if minLat > maxLat {
+		return 0, 0, 0, 0, fmt.Errorf("bbox minLat is above maxLat")
+	}
+	return minLng, minLat, maxLng, maxLat, nil
+}
+
+// geoHandler serves GET /api/tickets/geo?bbox=minLng,minLat,maxLng,maxLat: the pinned tickets
+// inside the box, paginated and filtered like GET /api/tickets
+func geoHandler(w http.ResponseWriter, r *http.Request) {
+	minLng, minLat, maxLng, maxLat, err := parseBBox(r.URL.Query().Get("bbox"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	filter, err := parseTicketFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	filter.conds = append(filter.conds, "lat BETWEEN ? AND ?")
+	filter.args = append(filter.args, minLat, maxLat)
+	if minLng <= maxLng {
+		filter.conds = append(filter.conds, "lng BETWEEN ? AND ?")
+	} else {
+		filter.conds = append(filter.conds, "(lng >= ? OR lng <= ?)")
+	}
+	filter.args = append(filter.args, minLng, maxLng)
+	writeTicketPage(w, r, filter)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+const healthTimeout = 2 * time.Second
+
+// healthHandler is the liveness probe: it only pings the DB and never touches the tickets table
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "db_unavailable"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyHandler is the readiness probe: the DB must also answer a trivial query
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+	var one int
+	if err := db.PingContext(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "db_unavailable"})
+		return
+	}
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil || one != 1 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not_ready"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// dbStatsHandler exposes db.Stats() for tuning the -db-max-* flags
+func dbStatsHandler(w http.ResponseWriter, r *http.Request) {
+	st := db.Stats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"max_open_connections": st.MaxOpenConnections,
+		"open_connections":     st.OpenConnections,
+		"in_use":               st.InUse,
+		"idle":                 st.Idle,
+		"wait_count":           st.WaitCount,
+		"wait_duration_ms":     st.WaitDuration.Milliseconds(),
+		"max_idle_closed":      st.MaxIdleClosed,
+		"max_idle_time_closed": st.MaxIdleTimeClosed,
+		"max_lifetime_closed":  st.MaxLifetimeClosed,
+	})
+}
+
+// wsStatsHandler serves GET /debug/wsstats: open admin websockets and why others were dropped
+func wsStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, broad.Stats())
+}
+
+// wsConnectionsHandler serves GET /api/ws/connections: how many dashboards are connected right now
+func wsConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, broad.Connections())
+}
+
+// wsDisconnectHandler serves POST /api/ws/disconnect with {"id":"..."} from GET /api/ws/connections,
+// closing that connection, e.g. one opened with a leaked token. Once the secret or roles are
+// rotated the client cannot connect again.
+func wsDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.ID == "" {
+		writeFieldErrors(w, FieldErrors{"id": "is required"})
+		return
+	}
+	if !broad.CloseConn(req.ID) {
+		writeError(w, http.StatusNotFound, "not_found", "connection not found")
+		return
+	}
+	log.Printf("ws connection %s disconnected by %s", req.ID, actorFromRequest(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+const (
+	// idempotencyWindow is how long a repeated Idempotency-Key returns the original ticket
+	idempotencyWindow    = 24 * time.Hour
+	maxIdempotencyKeyLen = 255
+)
+
+// idempotentTicket returns the ticket this client already created with key, if still within the window
+func idempotentTicket(ctx context.Context, ip, key string) (Ticket, bool, error) {
+	var id TicketID
+	err := db.QueryRowContext(ctx, "SELECT ticket_id FROM idempotency_keys WHERE client_ip = ? AND idem_key = ? AND created_at > "+idempotencyCutoff(),
+		ip, key, -int(idempotencyWindow.Seconds())).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Ticket{}, false, nil
+	}
+	if err != nil {
+		return Ticket{}, false, err
+	}
+	// the original response is replayed even if the ticket was deleted since
+	ts, err := queryTickets(ctx, db, "SELECT "+ticketColumns+" FROM tickets WHERE id = ?", id)
+	if err != nil || len(ts) == 0 {
+		return Ticket{}, false, err
+	}
+	return ts[0], true, nil
+}
+
+// rememberIdempotencyKey records key for ticketID in the creating transaction, replacing an expired entry.
+// A concurrent request that stored the same key first makes it fail with a duplicate key error.
+func rememberIdempotencyKey(ctx context.Context, q querier, ip, key string, ticketID TicketID) error {
+	_, err := q.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE client_ip = ? AND idem_key = ? AND created_at <= "+idempotencyCutoff(),
+		ip, key, -int(idempotencyWindow.Seconds()))
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, "INSERT INTO idempotency_keys (client_ip, idem_key, ticket_id) VALUES (?, ?, ?)", ip, key, ticketID)
+	return err
+}
+
+// idempotencyCutoff is the oldest created_at still inside the window; bind -idempotencyWindow seconds to it
+func idempotencyCutoff() string {
+	return sqlDialect.AddSeconds(sqlDialect.Now())
+}
+
+// purgeIdempotencyKeys periodically deletes keys older than the window
+func purgeIdempotencyKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		err := withRetry(ctx, func() error {
+			_, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at <= "+idempotencyCutoff(), -int(idempotencyWindow.Seconds()))
+			return err
+		})
+		cancel()
+		if err != nil {
+			log.Printf("purge idempotency keys: %v", err)
+		}
+	}
+}
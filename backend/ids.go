@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// stringIDs writes ticket ids as JSON strings (-string-ids), for JavaScript clients that would
+// lose precision on ids above 2^53
+var stringIDs bool
+
+// TicketID is the id of a ticket, an int64 like the BIGINT column. In JSON it is a number, or a
+// string with -string-ids; either form is accepted in requests.
+type TicketID int64
+
+func (id TicketID) MarshalJSON() ([]byte, error) {
+	if stringIDs {
+		return []byte(`"` + strconv.FormatInt(int64(id), 10) + `"`), nil
+	}
+	return strconv.AppendInt(nil, int64(id), 10), nil
+}
+
+func (id *TicketID) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if string(b) == "null" {
+		return nil
+	}
+	if n := len(b); n >= 2 && b[0] == '"' && b[n-1] == '"' {
+		b = b[1 : n-1]
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid ticket id %s", b)
+	}
+	*id = TicketID(n)
+	return nil
+}
+
+// parseTicketID parses a ticket id of a path or query, which must be positive
+func parseTicketID(raw string) (TicketID, error) {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid id %q", raw)
+	}
+	return TicketID(n), nil
+}
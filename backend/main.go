@@ -1,245 +1,800 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // -tz works on hosts without a zoneinfo database
+	"unicode/utf8"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Ticket struct used in DB and websocket messages
 type Ticket struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Phone       string    `json:"phone"`
-	Room        string    `json:"room"`
-	Description string    `json:"description"`
-	Status      string    `json:"status"`
-	Priority    string    `json:"priority"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          TicketID   `json:"id"`
+	Name        string     `json:"name"`
+	Phone       string     `json:"phone"`
+	Room        string     `json:"room"`
+	Description string     `json:"description"`
+	Anonymous   bool       `json:"anonymous"`
+	Status      string     `json:"status"`
+	Priority    string     `json:"priority"`
+	AssignedTo  *string    `json:"assigned_to,omitempty"`
+	Version     int        `json:"version"`
+	Tags        []string   `json:"tags,omitempty"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// MergedInto is the ticket this duplicate was merged into
+	MergedInto *TicketID `json:"merged_into,omitempty"`
+	// Lat and Lng pin the ticket on the campus map; both or neither are set
+	Lat *float64 `json:"lat,omitempty"`
+	Lng *float64 `json:"lng,omitempty"`
+	// Metadata holds a department's custom fields, e.g. {"assetTag": "INV-0042"}
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// PublicID is the unguessable id of GET /api/public/tickets/{public_id}; only admins and the
+	// reporter, in the POST response, get to see it
+	PublicID string `json:"public_id,omitempty"`
+	// Resolution says how the ticket was resolved, set by POST /api/tickets/{id}/resolve. ResolvedAt
+	// is set by any change into resolved. Both are cleared when the ticket is reopened.
+	Resolution *string    `json:"resolution,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
 }
 
-var db *sql.DB
+// ticketColumns is the column list shared by every ticket SELECT, in scanTicket order
+const ticketColumns = "id, name, phone, room, description, anonymous, status, priority, assigned_to, version, due_at, created_at, updated_at, deleted_at, merged_into, lat, lng, public_id, metadata, resolution, resolved_at"
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // ubah untuk produksi
-	},
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTicket(s rowScanner, t *Ticket) error {
+	if err := s.Scan(&t.ID, &t.Name, &t.Phone, &t.Room, &t.Description, &t.Anonymous, &t.Status, &t.Priority, &t.AssignedTo, &t.Version, &t.DueAt, &t.CreatedAt, &t.UpdatedAt, &t.DeletedAt, &t.MergedInto, &t.Lat, &t.Lng, &t.PublicID, metadataColumn{&t.Metadata}, &t.Resolution, &t.ResolvedAt); err != nil {
+		return err
+	}
+	// dirty rows (see checkEnumValues) are served rather than failing the whole list
+	t.Status, t.Priority = knownOr(allowedStatuses, t.Status), knownOr(allowedPriorities, t.Priority)
+	inDisplayZone(&t.CreatedAt, &t.UpdatedAt, t.DueAt, t.DeletedAt, t.ResolvedAt)
+	return nil
 }
 
-// broadcaster: manages admin websocket connections and broadcasting messages
-type Broadcaster struct {
-	mu    sync.Mutex
-	conns map[*websocket.Conn]bool
+// displayLoc is the zone timestamps are encoded in (-tz); the database itself is kept in UTC
+var displayLoc = time.UTC
+
+// inDisplayZone moves each timestamp to displayLoc, skipping nil ones
+func inDisplayZone(ts ...*time.Time) {
+	for _, t := range ts {
+		if t != nil {
+			*t = t.In(displayLoc)
+		}
+	}
 }
 
-func NewBroadcaster() *Broadcaster {
-	return &Broadcaster{conns: make(map[*websocket.Conn]bool)}
+// querier is satisfied by both db and its transactions
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
-func (b *Broadcaster) Add(c *websocket.Conn) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	b.conns[c] = true
+// dbTimeout bounds every request's DB work (-db-timeout)
+var dbTimeout = 10 * time.Second
+
+// dbContext derives the context for a request's DB calls, cancelled when the client goes away
+func dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), dbTimeout)
 }
 
-func (b *Broadcaster) Remove(c *websocket.Conn) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	delete(b.conns, c)
+// dbError answers 504 when the DB call ran out of time and 500 otherwise
+func dbError(w http.ResponseWriter, err error) {
+	dbErrors.Inc()
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, "database_timeout", "database timeout")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
 }
 
-func (b *Broadcaster) Broadcast(event string, payload interface{}) {
-	msg := map[string]interface{}{"event": event, "payload": payload}
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	for c := range b.conns {
-		c.SetWriteDeadline(time.Now().Add(5 * time.Second))
-		if err := c.WriteJSON(msg); err != nil {
-			log.Printf("ws write error: %v, removing connection", err)
-			c.Close()
-			delete(b.conns, c)
+// loadTicket reads a ticket that hasn't been soft-deleted, returning sql.ErrNoRows if there is none
+func loadTicket(ctx context.Context, q querier, id TicketID) (Ticket, error) {
+	ts, err := queryTickets(ctx, q, "SELECT "+ticketColumns+" FROM tickets WHERE id = ? AND deleted_at IS NULL", id)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if len(ts) == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+	return ts[0], nil
+}
+
+// queryTickets runs a SELECT of ticketColumns and returns the tickets with their tags (never nil)
+func queryTickets(ctx context.Context, q querier, query string, args ...interface{}) ([]Ticket, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	res := []Ticket{}
+	for rows.Next() {
+		var t Ticket
+		if err := scanTicket(rows, &t); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		res = append(res, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
+	return res, attachTags(ctx, q, res)
 }
 
-var broad = NewBroadcaster()
+var db *timedDB
+
+// writeJSON encodes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError is the body of every error response, {"error":{"code":"invalid_id","message":"..."}}.
+// Clients branch on Code, so a code never changes once shipped; Message is for humans.
+type apiError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Fields  FieldErrors `json:"fields,omitempty"`
+	// Items lists the invalid elements of a batch request
+	Items []itemErrors `json:"items,omitempty"`
+}
+
+// itemErrors is what is wrong with one element of a batch, by its position in the request array
+type itemErrors struct {
+	Index  int         `json:"index"`
+	Fields FieldErrors `json:"fields"`
+}
+
+// writeError answers with status and an apiError
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{"error": apiError{Code: code, Message: message}})
+}
+
+// writeFieldErrors answers 422 validation_failed, listing what is wrong with each field
+func writeFieldErrors(w http.ResponseWriter, errs FieldErrors) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": apiError{Code: "validation_failed", Message: errs.Error(), Fields: errs}})
+}
+
+// writeJSONWithETag writes v with a strong ETag hashed from its encoding, or 304 without a body
+// when If-None-Match already names it. Every byte of the body is covered, so any change to the
+// row, or to how it is rendered for this caller, gives a new ETag.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+		return
+	}
+	body = append(body, '\n') // same bytes as writeJSON
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, no-cache")
+	// admins and the public see different bodies for anonymous tickets
+	h.Add("Vary", "Authorization")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// etagMatches is the weak comparison of If-None-Match: "*" or any listed tag, with or without W/
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimPrefix(strings.TrimSpace(t), "W/"); t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBodyBytes caps JSON request bodies (-max-body-bytes)
+var maxBodyBytes int64 = 1 << 20
+
+// decodeJSON reads the request body into v. A body not declared as application/json gets 415,
+// bodies over maxBodyBytes 413, an empty body, malformed JSON and unknown fields 400; it reports
+// whether v was decoded.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	// parameters such as charset=utf-8 are fine, JSON is UTF-8 anyway
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "unsupported_media_type", "the request body must be JSON, sent with Content-Type: application/json")
+		return false
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, "payload_too_large", "request body too large")
+			return false
+		}
+		if err == io.EOF {
+			writeError(w, http.StatusBadRequest, "empty_body", "request body is empty, expected JSON")
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "invalid_json", "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     wsCheckOrigin,
+}
 
 func main() {
 	// flags for config
 	addr := flag.String("addr", ":8080", "http service address")
-	dsn := flag.String("dsn", "root:password@tcp(127.0.0.1:3306)/ticketing_db?parseTime=true", "MySQL DSN")
+	driver := flag.String("db-driver", "mysql", "database driver: mysql or sqlite")
+	dsn := flag.String("dsn", "root:password@tcp(127.0.0.1:3306)/ticketing_db?parseTime=true", "MySQL DSN, or SQLite file DSN with -db-driver sqlite")
+	replicaDSN := flag.String("dsn-replica", "", "DSN of a read replica for list, search, single ticket, stats and feed queries (empty: the primary serves them)")
 	staticDir := flag.String("static", "../static", "static files dir")
+	flag.DurationVar(&staticMaxAge, "static-max-age", staticMaxAge, "browser cache lifetime of static assets without a content hash in their name")
+	secret := flag.String("jwt-secret", "", "HMAC secret for admin JWTs (empty disables auth)")
+	flag.BoolVar(&checkAgents, "check-agents", false, "only allow assigning tickets to names in the agents table")
+	adminIPs := flag.String("admin-ip-allowlist", "", "comma separated CIDRs admin routes, websockets and event streams answer (empty: any address)")
+	proxies := flag.String("trusted-proxy", "", "comma separated CIDRs of reverse proxies whose X-Forwarded-For gives the client address")
+	origins := flag.String("allowed-origins", "", "comma separated origins allowed for CORS and websockets (empty: same-origin only)")
+	createRate := flag.Float64("create-rate", 5, "ticket creations allowed per minute per IP (0 disables the limit)")
+	createBurst := flag.Int("create-burst", 3, "burst of ticket creations allowed per IP")
+	slaFlags := map[string]*time.Duration{}
+	for _, p := range allowedPriorities {
+		slaFlags[p] = flag.Duration("sla-"+p, slaDurations[p], "response deadline for "+p+" priority tickets")
+	}
+	flag.BoolVar(&sanitizeHTML, "sanitize-html", sanitizeHTML, "strip HTML tags from ticket names, rooms and descriptions on write")
+	flag.IntVar(&maxOpenPerPhone, "max-open-per-phone", maxOpenPerPhone, "open tickets one phone number may have at once (0 = no limit)")
+	flag.StringVar(&defaultRole, "default-role", defaultRole, "role of admin tokens without a role claim: "+strings.Join(roles, ", "))
+	flag.StringVar(&defaultCountry, "default-country", defaultCountry, "calling code for phone numbers entered without one, e.g. 62")
+	slaScan := flag.Duration("sla-scan-interval", time.Minute, "how often to look for newly overdue tickets")
+	maxOpen := flag.Int("db-max-open", 25, "maximum open DB connections (0 = unlimited)")
+	maxIdle := flag.Int("db-max-idle", 25, "maximum idle DB connections")
+	connLifetime := flag.Duration("db-conn-max-lifetime", 5*time.Minute, "maximum lifetime of a DB connection (0 = forever)")
+	autoCloseInterval := flag.Duration("autoclose-interval", time.Hour, "how often to auto-close stale resolved tickets (0 disables)")
+	autoCloseDays := flag.Int("autoclose-after-days", 7, "days a ticket may stay resolved before it is closed automatically")
+	retentionDays := flag.Int("retention-days", 0, "days after closing, or deleting, that a ticket is purged (0 keeps tickets forever)")
+	retentionMode := flag.String("retention-mode", "anonymize", "what the purge does: "+strings.Join(retentionModes, ", "))
+	retentionInterval := flag.Duration("retention-interval", time.Hour, "how often to look for tickets past -retention-days")
+	escalateInterval := flag.Duration("escalate-interval", 0, "how often to raise the priority of overdue unassigned tickets (0 disables)")
+	escalation := flag.String("escalation-rule", "low:medium,medium:high,high:urgent,urgent:critical", "comma separated from:to priority steps of -escalate-interval")
+	flag.Int64Var(&maxBodyBytes, "max-body-bytes", maxBodyBytes, "maximum size of a JSON request body")
+	emailSecret := flag.String("email-webhook-secret", "", "shared secret signing inbound email webhooks (empty disables POST /api/tickets/email)")
+	flag.IntVar(&dbRetries, "db-retries", dbRetries, "attempts for DB writes failing with deadlocks, lock timeouts or refused connections")
+	tz := flag.String("tz", "UTC", "IANA time zone for timestamps in responses, e.g. Asia/Jakarta")
+	coalesceMs := flag.Int("broadcast-coalesce-ms", int(coalesceWindow/time.Millisecond), "window in ms within which ticket_updated events for one ticket are coalesced (0 sends every one)")
+	defaultSort := flag.String("default-sort", "-created_at", "order of ticket lists without ?sort= and of the websocket init: a ?sort= key, prefix - for descending")
+	flag.BoolVar(&stringIDs, "string-ids", false, "write ticket ids as JSON strings, for JavaScript clients that lose precision above 2^53")
+	flag.BoolVar(&broadcastDiffs, "broadcast-diffs", false, "ticket_updated events carry only the changed fields; off sends the whole ticket")
+	flag.DurationVar(&ackWindow, "ack-window", ackWindow, "how long a ticket_critical alert may go unacknowledged before the on-call team is emailed again (0 = never)")
+	flag.IntVar(&maxWsConnections, "max-ws-connections", maxWsConnections, "open websocket connections allowed at once; further upgrades get a 503 (0 = no limit)")
+	flag.IntVar(&wsInitLimit, "ws-init-limit", wsInitLimit, "newest tickets sent when an admin websocket connects (1-200)")
+	flag.IntVar(&feedSize, "feed-size", feedSize, "newest tickets listed in GET /api/tickets/feed.xml (1-200)")
+	autoMigrate := flag.Bool("migrate", true, "apply pending schema migrations at startup")
+	enforceEnums := flag.Bool("enforce-enum-constraints", false, "add CHECK constraints for ticket status and priority on startup (MySQL 8.0.16+)")
+	flag.DurationVar(&dbTimeout, "db-timeout", dbTimeout, "timeout for the DB work of a single request")
+	slowQueryMs := flag.Int("slow-query-ms", int(slowQueryThreshold/time.Millisecond), "log statements taking at least this many ms as slow queries (0 disables)")
+	flag.StringVar(&notifier.host, "smtp-host", "", "SMTP server host:port for high priority alerts (empty disables email)")
+	flag.StringVar(&notifier.user, "smtp-user", "", "SMTP username, also used as the From address")
+	flag.StringVar(&notifier.pass, "smtp-pass", "", "SMTP password")
+	notifyTo := flag.String("notify-to", "", "comma separated recipients of high priority alerts")
+	notifyLevelList := flag.String("notify-levels", "", "comma separated priority:level overrides of the notify hint (info, warn or critical) on ticket_created and ticket_critical broadcasts")
+	flag.StringVar(&uploadsDir, "uploads-dir", uploadsDir, "directory where ticket attachments are stored")
+	flag.Int64Var(&maxUploadBytes, "max-upload-bytes", maxUploadBytes, "maximum size of one attachment")
+	metadataKeys := flag.String("metadata-filter-keys", "", "comma separated metadata keys GET /api/tickets may filter on with ?metadata.<key>=")
+	uploadExts := flag.String("upload-extensions", strings.Join(allowedUploadExts, ","), "comma separated attachment extensions accepted; the file contents must match")
+	metricsAddr := flag.String("metrics-addr", "", "separate address for /metrics (empty: serve it on -addr)")
+	enablePprof := flag.Bool("enable-pprof", false, "serve the Go profiler under /debug/pprof/, on -metrics-addr when set, else on -addr for admins only")
+	flag.BoolVar(&listLastModified, "list-last-modified", false, "GET /api/tickets sends Last-Modified and answers If-Modified-Since with 304 when nothing in the result is newer")
+	flag.BoolVar(&publicIDsOnly, "public-ids-only", false, "without a token, look tickets up by public_id only; the routes showing integer ids (GET /api/tickets/{id}, the lists, comments and attachments, uploads by id) answer 404; reporters upload through /api/public/tickets/{public_id}/attachments")
+	enableGzip := flag.Bool("enable-gzip", false, "gzip responses of 1 KiB or more for clients that accept it")
+	readHeaderTimeout := flag.Duration("read-header-timeout", 10*time.Second, "time allowed to read request headers (0 = no limit)")
+	readTimeout := flag.Duration("read-timeout", time.Minute, "time allowed to read a whole request, body included (0 = no limit)")
+	writeTimeout := flag.Duration("write-timeout", time.Minute, "time allowed to write a response (0 = no limit); websockets and event streams are exempt")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "how long an idle keep-alive connection stays open (0 = use -read-timeout)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "grace period for in-flight requests on shutdown")
 	flag.Parse()
 
-	var err error
-	db, err = sql.Open("mysql", *dsn)
+	for p, d := range slaFlags {
+		slaDurations[p] = *d
+	}
+	for _, to := range strings.Split(*notifyTo, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			notifier.to = append(notifier.to, to)
+		}
+	}
+	if *coalesceMs < 0 {
+		log.Fatalf("-broadcast-coalesce-ms must not be negative, got %d", *coalesceMs)
+	}
+	coalesceWindow = time.Duration(*coalesceMs) * time.Millisecond
+	if *slowQueryMs < 0 {
+		log.Fatalf("-slow-query-ms must not be negative, got %d", *slowQueryMs)
+	}
+	slowQueryThreshold = time.Duration(*slowQueryMs) * time.Millisecond
+	if wsInitLimit < 1 || wsInitLimit > maxPerPage {
+		log.Fatalf("-ws-init-limit must be between 1 and %d", maxPerPage)
+	}
+	if maxWsConnections < 0 {
+		log.Fatalf("-max-ws-connections must not be negative, got %d", maxWsConnections)
+	}
+	if feedSize < 1 || feedSize > maxPerPage {
+		log.Fatalf("-feed-size must be between 1 and %d", maxPerPage)
+	}
+	defaultCountry = strings.TrimPrefix(defaultCountry, "+")
+	if !countryCode.MatchString(defaultCountry) {
+		log.Fatalf("-default-country must be a calling code like 62, got %q", defaultCountry)
+	}
+	if !slices.Contains(roles, defaultRole) {
+		log.Fatalf("-default-role must be one of %s, got %q", strings.Join(roles, ", "), defaultRole)
+	}
+	allowedOrigins = parseOrigins(*origins)
+	jwtSecret = []byte(*secret)
+	emailWebhookSecret = []byte(*emailSecret)
+	if len(jwtSecret) == 0 {
+		log.Printf("warning: -jwt-secret not set, admin endpoints are unauthenticated")
+	}
+
+	exts, err := parseUploadExtensions(*uploadExts)
+	if err != nil {
+		log.Fatalf("-upload-extensions: %v", err)
+	}
+	allowedUploadExts = exts
+	if metadataFilterKeys, err = parseMetadataFilterKeys(*metadataKeys); err != nil {
+		log.Fatalf("-metadata-filter-keys: %v", err)
+	}
+	if defaultOrderBy, err = sortClause(*defaultSort); err != nil {
+		log.Fatalf("-default-sort: %v", err)
+	}
+	if adminAllowlist, err = parsePrefixes(*adminIPs); err != nil {
+		log.Fatalf("-admin-ip-allowlist: %v", err)
+	}
+	if trustedProxies, err = parsePrefixes(*proxies); err != nil {
+		log.Fatalf("-trusted-proxy: %v", err)
+	}
+	if escalationRule, err = parseEscalationRule(*escalation); err != nil {
+		log.Fatalf("-escalation-rule: %v", err)
+	}
+	if notifyLevels, err = parseNotifyLevels(*notifyLevelList); err != nil {
+		log.Fatalf("-notify-levels: %v", err)
+	}
+	if *retentionDays < 0 {
+		log.Fatalf("-retention-days must not be negative")
+	}
+	if !slices.Contains(retentionModes, *retentionMode) {
+		log.Fatalf("-retention-mode must be one of %s", strings.Join(retentionModes, ", "))
+	}
+	if *retentionDays > 0 && *retentionInterval <= 0 {
+		log.Fatalf("-retention-interval must be positive")
+	}
+	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+		log.Fatalf("uploads dir: %v", err)
+	}
+
+	d, ok := dialects[*driver]
+	if !ok {
+		log.Fatalf("unknown -db-driver %q (want mysql or sqlite)", *driver)
+	}
+	sqlDialect = d
+	if displayLoc, err = time.LoadLocation(*tz); err != nil {
+		log.Fatalf("-tz: %v", err)
+	}
+	connDSN, err := sqlDialect.DSN(*dsn)
+	if err != nil {
+		log.Fatalf("-dsn: %v", err)
+	}
+	sqlDB, err := sql.Open(*driver, connDSN)
 	if err != nil {
 		log.Fatalf("db open: %v", err)
 	}
-	defer db.Close()
+	db = &timedDB{sqlDB}
+	db.SetMaxOpenConns(*maxOpen)
+	db.SetMaxIdleConns(*maxIdle)
+	db.SetConnMaxLifetime(*connLifetime)
+	log.Printf("db pool: max_open=%d max_idle=%d conn_max_lifetime=%s", *maxOpen, *maxIdle, *connLifetime)
 
 	if err = db.Ping(); err != nil {
 		log.Fatalf("db ping: %v", err)
 	}
-
-	mux := http.NewServeMux()
-	// serve static files (index.html, admin.html, styles.css)
-	mux.Handle("/", http.FileServer(http.Dir(*staticDir)))
-	mux.HandleFunc("/api/tickets", ticketsHandler)      // GET, POST
-	mux.HandleFunc("/api/tickets/", ticketItemHandler) // GET, PUT, DELETE
-	mux.HandleFunc("/ws/admin", adminWsHandler)        // websocket for admins
-
-	log.Printf("Server starting on %s", *addr)
-	log.Fatal(http.ListenAndServe(*addr, mux))
-}
-
-// ticketsHandler supports GET (list) and POST (create)
-func ticketsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		rows, err := db.Query("SELECT id, name, phone, room, description, status, priority, created_at, updated_at FROM tickets ORDER BY created_at DESC")
+	if *replicaDSN != "" {
+		replicaConnDSN, err := sqlDialect.DSN(*replicaDSN)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-		var res []Ticket
-		for rows.Next() {
-			var t Ticket
-			if err := rows.Scan(&t.ID, &t.Name, &t.Phone, &t.Room, &t.Description, &t.Status, &t.Priority, &t.CreatedAt, &t.UpdatedAt); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			res = append(res, t)
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(res)
-
-	case http.MethodPost:
-		var t Ticket
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "invalid json", http.StatusBadRequest)
-			return
+			log.Fatalf("-dsn-replica: %v", err)
 		}
-		q := `INSERT INTO tickets (name, phone, room, description, status, priority) VALUES (?, ?, ?, ?, ?, ?)`
-		res, err := db.Exec(q, t.Name, t.Phone, t.Room, t.Description, t.Status, t.Priority)
+		replicaDB, err := sql.Open(*driver, replicaConnDSN)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			log.Fatalf("db replica open: %v", err)
+		}
+		replica = &timedDB{replicaDB}
+		replica.SetMaxOpenConns(*maxOpen)
+		replica.SetMaxIdleConns(*maxIdle)
+		replica.SetConnMaxLifetime(*connLifetime)
+		// unlike the primary, a replica that is down at startup is not fatal
+		if err := replica.Ping(); err != nil {
+			log.Printf("db replica ping: %v; read queries go to the primary until it answers", err)
+		} else {
+			replicaUp.Store(true)
+			log.Printf("db replica: up, read queries go to it")
+		}
+		go watchReplica()
+	}
+	if *autoMigrate {
+		if err = migrate(context.Background(), *driver); err != nil {
+			log.Fatalf("db migrate: %v", err)
+		}
+	}
+	if err = broad.restoreEvents(context.Background()); err != nil {
+		log.Fatalf("broadcast events: %v", err)
+	}
+	if err = checkEnumValues(context.Background()); err != nil {
+		log.Printf("enum check: %v", err)
+	}
+	if *enforceEnums {
+		if err = enforceEnumConstraints(context.Background(), *driver); err != nil {
+			log.Fatalf("-enforce-enum-constraints: %v", err)
 		}
-		id, _ := res.LastInsertId()
-		t.ID = int(id)
-		// read created_at / updated_at
-		_ = db.QueryRow("SELECT created_at, updated_at FROM tickets WHERE id = ?", id).Scan(&t.CreatedAt, &t.UpdatedAt)
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(t)
-
-		// broadcast new ticket to admin websockets
-		broad.Broadcast("ticket_created", t)
-
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
-}
 
-// ticketItemHandler supports GET /:id, PUT /:id, DELETE /:id
-func ticketItemHandler(w http.ResponseWriter, r *http.Request) {
-	// simple path parsing: /api/tickets/{id}
-	var id int
-	_, err := fmt.Sscanf(r.URL.Path, "/api/tickets/%d", &id)
-	if err != nil || id == 0 {
-		http.Error(w, "invalid id", http.StatusBadRequest)
-		return
+	createHandler := createTicketHandler
+	if *createRate > 0 {
+		createHandler = newIPLimiter(*createRate, *createBurst, 10*time.Minute).middleware(createTicketHandler)
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		var t Ticket
-		if err := db.QueryRow("SELECT id, name, phone, room, description, status, priority, created_at, updated_at FROM tickets WHERE id = ?", id).
-			Scan(&t.ID, &t.Name, &t.Phone, &t.Room, &t.Description, &t.Status, &t.Priority, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			if err == sql.ErrNoRows {
-				http.NotFound(w, r)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		json.NewEncoder(w).Encode(t)
+	// staff routes: viewers may read, only admins may change anything
+	viewer, admin := requireRole("viewer"), requireRole("admin")
+	mux := http.NewServeMux()
+	// serve static files (index.html, admin.html, styles.css)
+	mux.Handle("GET /", staticHandler(*staticDir))
+	mux.HandleFunc("GET /api/openapi.json", openAPIHandler)
+	mux.HandleFunc("GET /api/tickets", hideIntegerIDs(listTicketsHandler))
+	mux.HandleFunc("POST /api/tickets", createHandler)
+	mux.HandleFunc("POST /api/tickets/validate", validateTicketHandler)
+	if len(emailWebhookSecret) > 0 {
+		mux.HandleFunc("POST /api/tickets/email", emailTicketHandler)
+	}
+	mux.HandleFunc("GET /api/tickets/search", hideIntegerIDs(searchHandler))
+	mux.HandleFunc("GET /api/tickets/overdue", hideIntegerIDs(overdueTicketsHandler))
+	mux.HandleFunc("GET /api/tickets/stats", statsHandler)
+	mux.HandleFunc("GET /api/tickets/geo", hideIntegerIDs(geoHandler))
+	mux.HandleFunc("GET /api/tickets/feed.xml", hideIntegerIDs(feedHandler))
+	mux.HandleFunc("GET /api/tickets/stream", adminNetwork(streamHandler)) // SSE for admins, checks ?token= itself
+	mux.HandleFunc("PATCH /api/tickets/bulk", admin(bulkStatusHandler))
+	mux.HandleFunc("POST /api/agents/{from}/reassign", admin(reassignAgentHandler))
+	mux.HandleFunc("POST /api/tickets/batch", admin(batchCreateHandler))
+	mux.HandleFunc("POST /api/tickets/import.csv", admin(importCSVHandler))
+	mux.HandleFunc("GET /api/tickets/{id}", getTicketHandler)
+	mux.HandleFunc("PUT /api/tickets/{id}", admin(updateTicketHandler))
+	mux.HandleFunc("PATCH /api/tickets/{id}", admin(patchTicketHandler))
+	mux.HandleFunc("DELETE /api/tickets/{id}", admin(deleteTicketHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/restore", admin(restoreTicketHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/assign", admin(assignTicketHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/reopen", admin(reopenTicketHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/resolve", admin(resolveTicketHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/merge", admin(mergeTicketHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/history", viewer(historyHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/acks", viewer(acksHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/full", viewer(fullTicketHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/events", adminNetwork(ticketEventsHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/comments", hideIntegerIDs(listCommentsHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/comments", admin(createCommentHandler))
+	mux.HandleFunc("GET /api/tickets/{id}/attachments", hideIntegerIDs(listAttachmentsHandler))
+	mux.HandleFunc("POST /api/tickets/{id}/attachments", hideIntegerIDs(uploadAttachmentHandler))
+	mux.HandleFunc("GET /api/attachments/{id}", hideIntegerIDs(downloadAttachmentHandler))
+	mux.HandleFunc("GET /api/public/tickets/{public_id}", publicTicketHandler)
+	mux.HandleFunc("POST /api/public/tickets/{public_id}/attachments", publicUploadAttachmentHandler)
+	mux.HandleFunc("GET /api/rooms", roomsHandler)
+	mux.HandleFunc("GET /api/version", versionHandler)
+	mux.HandleFunc("GET /api/meta", metaHandler)
+	mux.HandleFunc("GET /api/ws/connections", admin(wsConnectionsHandler))
+	mux.HandleFunc("POST /api/ws/disconnect", admin(wsDisconnectHandler))
+	mux.HandleFunc("GET /ws/admin", adminNetwork(adminWsHandler))      // websocket for admins, checks ?token= itself
+	mux.HandleFunc("GET /ws/room/{room}", adminNetwork(roomWsHandler)) // the same, limited to one room
+	mux.HandleFunc("GET /healthz", healthHandler)                      // liveness
+	mux.HandleFunc("GET /readyz", readyHandler)                        // readiness
 
-	case http.MethodPut:
-		var t Ticket
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			http.Error(w, "invalid json", http.StatusBadRequest)
-			return
-		}
-		q := `UPDATE tickets SET name=?, phone=?, room=?, description=?, status=?, priority=? WHERE id=?`
-		_, err := db.Exec(q, t.Name, t.Phone, t.Room, t.Description, t.Status, t.Priority, id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	// the timeouts keep slow or stalled clients (slowloris) from holding connections forever.
+	// Long-lived responses are not cut off by the write timeout: the websocket upgrade clears the
+	// connection's deadlines, and event streams move theirs forward before every write.
+	withTimeouts := func(s *http.Server) {
+		s.ReadHeaderTimeout = *readHeaderTimeout
+		s.ReadTimeout = *readTimeout
+		s.WriteTimeout = *writeTimeout
+		s.IdleTimeout = *idleTimeout
+	}
+	var metricsSrv *http.Server
+	if *metricsAddr == "" {
+		mux.Handle("GET /metrics", promhttp.Handler())
+		mux.HandleFunc("GET /debug/dbstats", viewer(dbStatsHandler)) // connection pool stats
+		mux.HandleFunc("GET /debug/wsstats", viewer(wsStatsHandler)) // websocket drops and failed writes
+		if *enablePprof {
+			registerPprof(mux, admin)
 		}
-		// fetch updated row
-		if err := db.QueryRow("SELECT id, name, phone, room, description, status, priority, created_at, updated_at FROM tickets WHERE id = ?", id).
-			Scan(&t.ID, &t.Name, &t.Phone, &t.Room, &t.Description, &t.Status, &t.Priority, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	} else {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", promhttp.Handler())
+		metricsMux.HandleFunc("GET /debug/dbstats", adminNetwork(dbStatsHandler))
+		metricsMux.HandleFunc("GET /debug/wsstats", adminNetwork(wsStatsHandler))
+		if *enablePprof {
+			registerPprof(metricsMux, adminNetwork)
 		}
-		json.NewEncoder(w).Encode(t)
-		broad.Broadcast("ticket_updated", t)
+		metricsSrv = &http.Server{Addr: *metricsAddr, Handler: metricsMux}
+		withTimeouts(metricsSrv)
+		go func() {
+			log.Printf("Metrics on %s", *metricsAddr)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("metrics listen: %v", err)
+			}
+		}()
+	}
 
-	case http.MethodDelete:
-		_, err := db.Exec("DELETE FROM tickets WHERE id = ?", id)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	go watchOverdue(*slaScan)
+	go purgeIdempotencyKeys(time.Hour)
+	if *autoCloseInterval > 0 {
+		go watchAutoClose(*autoCloseInterval, time.Duration(*autoCloseDays)*24*time.Hour)
+	}
+	if *retentionDays > 0 {
+		log.Printf("retention: tickets closed or deleted more than %d days ago are purged (%s)", *retentionDays, *retentionMode)
+		go watchRetention(*retentionInterval, time.Duration(*retentionDays)*24*time.Hour, *retentionMode)
+	}
+	if *escalateInterval > 0 {
+		go watchEscalation(*escalateInterval)
+	}
+
+	var handler http.Handler = mux
+	if *enableGzip {
+		handler = gzipMiddleware(handler)
+	}
+	srv := &http.Server{Addr: *addr, Handler: metricsMiddleware(corsMiddleware(handler))}
+	withTimeouts(srv)
+	srv.RegisterOnShutdown(func() {
+		log.Printf("closed %d event streams", broad.CloseStreams())
+	})
+	go func() {
+		log.Printf("Server %s starting on %s", Version, *addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("listen: %v", err)
 		}
-		w.WriteHeader(http.StatusNoContent)
-		broad.Broadcast("ticket_deleted", map[string]int{"id": id})
+	}()
+
+	// wait for SIGINT/SIGTERM, then drain in-flight requests and websockets
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("received %v, shutting down (grace %s)", sig, *shutdownTimeout)
 
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+	log.Printf("closed %d websocket connections", broad.Shutdown(ctx))
+	if err := db.Close(); err != nil {
+		log.Printf("db close: %v", err)
+	}
+	if replica != nil {
+		replica.Close()
 	}
 }
 
+// wsInitLimit is how many tickets the websocket init message carries (-ws-init-limit).
+// It doubles as the page size the admin page uses to load older tickets, so it stays within maxPerPage.
+var wsInitLimit = 100
+
 // adminWsHandler upgrades connection and keeps it open. Admin clients receive broadcasts
 func adminWsHandler(w http.ResponseWriter, r *http.Request) {
+	serveWs(w, r, "")
+}
+
+// roomWsHandler serves GET /ws/room/{room}: like /ws/admin, but the connection only ever gets
+// the tickets of that room, for facilities staff watching one building
+func roomWsHandler(w http.ResponseWriter, r *http.Request) {
+	room := strings.TrimSpace(r.PathValue("room"))
+	switch {
+	case room == "":
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "room is required")
+		return
+	case utf8.RuneCountInString(room) > maxRoomLen:
+		writeError(w, http.StatusBadRequest, "invalid_parameter", fmt.Sprintf("room must be at most %d characters", maxRoomLen))
+		return
+	}
+	serveWs(w, r, room)
+}
+
+// serveWs runs an admin websocket; a non-empty room pins its subscription to that room
+func serveWs(w http.ResponseWriter, r *http.Request, room string) {
+	// reject before upgrading so the client sees a plain 401
+	if !checkRole(w, wsToken(r), "viewer") {
+		return
+	}
+	if broad.WsFull() {
+		wsRejected.Inc()
+		writeError(w, http.StatusServiceUnavailable, "too_many_connections", "too many dashboard connections, try again later")
+		return
+	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("upgrade error: %v", err)
 		return
 	}
 	defer c.Close()
-	broad.Add(c)
-	// send current ticket list immediately
-	rows, err := db.Query("SELECT id, name, phone, room, description, status, priority, created_at, updated_at FROM tickets ORDER BY created_at DESC")
-	if err == nil {
-		var res []Ticket
-		for rows.Next() {
-			var t Ticket
-			_ = rows.Scan(&t.ID, &t.Name, &t.Phone, &t.Room, &t.Description, &t.Status, &t.Priority, &t.CreatedAt, &t.UpdatedAt)
-			res = append(res, t)
+	// every pong (answer to writeLoop's pings) extends the read deadline
+	c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	// hello goes out before the writer starts, so it is always the first message
+	if err := sendWsHello(c); err != nil {
+		log.Printf("ws hello %s: %v", c.RemoteAddr(), err)
+		return
+	}
+	var base wsFilter
+	if room != "" {
+		base.Room = []string{room}
+	}
+	// the check before upgrading can race with other upgrades, Add decides for good
+	if !broad.Add(c, base, tokenSubject(wsToken(r))) {
+		wsRejected.Inc()
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many dashboard connections"), time.Now().Add(writeWait))
+		return
+	}
+	// a reconnecting client passes the last seq it saw and only gets what it missed
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		since, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || !broad.Resync(c, since) {
+			sendWsReload(c)
 		}
-		_ = c.WriteJSON(map[string]interface{}{"event": "init", "payload": res})
+	} else {
+		sendWsInit(r, c, base)
 	}
 
-	// keep reading to detect closed connection
+	// keep reading to detect closed connection and handle subscriptions and resyncs:
+	// {"action":"subscribe","filters":{"priority":["high"],"room":["A1"]}}
+	// {"action":"resync","since":42}
+	// {"action":"ack","ack_id":"crit-42"}
 	for {
-		var msg map[string]interface{}
+		var msg struct {
+			Action  string   `json:"action"`
+			Filters wsFilter `json:"filters"`
+			Since   uint64   `json:"since"`
+			AckID   string   `json:"ack_id"`
+		}
 		if err := c.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				broad.Send(c, "error", map[string]string{"error": "invalid json: " + err.Error()})
+				continue
+			}
 			break
 		}
+		switch msg.Action {
+		case "subscribe":
+			f, err := normalizeWsFilter(msg.Filters)
+			if err != nil {
+				broad.Send(c, "error", map[string]string{"error": err.Error()})
+				continue
+			}
+			// a room channel may narrow by priority but stays on its room
+			if room != "" {
+				f.Room = base.Room
+			}
+			broad.Subscribe(c, f)
+			// resend the snapshot so the dashboard only shows what it now subscribes to
+			sendWsInit(r, c, f)
+		case "resync":
+			if !broad.Resync(c, msg.Since) {
+				sendWsReload(c)
+			}
+		case "ack":
+			ackWs(r, c, msg.AckID)
+		default:
+			broad.Send(c, "error", map[string]string{"error": fmt.Sprintf("unknown action %q", msg.Action)})
+		}
 	}
 	broad.Remove(c)
 }
+
+// ackWs records that c received the critical alert ackID and confirms it with an "acked" event
+func ackWs(r *http.Request, c *websocket.Conn, ackID string) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := recordAck(ctx, ackID, broad.ConnID(c), tokenSubject(wsToken(r)))
+	if errors.Is(err, errUnknownAck) {
+		broad.Send(c, "error", map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("ws ack %s: %v", ackID, err)
+		broad.Send(c, "error", map[string]string{"error": "could not record the ack"})
+		return
+	}
+	broad.Send(c, "acked", map[string]interface{}{"ack_id": ackID, "ticket_id": id})
+}
+
+// sendWsReload tells c that what it asked for is outside the replay window, so it must reconnect for a fresh init
+func sendWsReload(c subscriber) {
+	broad.Send(c, "reload", map[string]interface{}{"seq": broad.Seq(), "window": replayWindow})
+}
+
+// sendWsInit sends c the first tickets matching f in defaultOrderBy; the rest are paged in through GET /api/tickets.
+// Its seq is read before the query, so resyncing from it can only repeat changes, never miss one.
+func sendWsInit(r *http.Request, c *websocket.Conn, f wsFilter) {
+	filter := &ticketFilter{conds: []string{"deleted_at IS NULL"}}
+	if len(f.Priority) > 0 {
+		filter.in("priority", f.Priority)
+	}
+	if len(f.Room) > 0 {
+		filter.in("room", f.Room)
+	}
+	seq := broad.Seq()
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	res, err := queryTickets(ctx, db, "SELECT "+ticketColumns+" FROM tickets"+filter.where()+defaultOrderBy+" LIMIT ?", append(filter.args, wsInitLimit)...)
+	var total int
+	if err == nil {
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tickets"+filter.where(), filter.args...).Scan(&total)
+	}
+	if err != nil {
+		log.Printf("ws init: %v", err)
+		return
+	}
+	broad.Send(c, "init", map[string]interface{}{"tickets": res, "total": total, "has_more": total > len(res), "seq": seq})
+}
+
+// normalizeWsFilter trims the subscription values and checks priorities against allowedPriorities
+func normalizeWsFilter(f wsFilter) (wsFilter, error) {
+	var out wsFilter
+	for _, p := range f.Priority {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !slices.Contains(allowedPriorities, p) {
+			return wsFilter{}, fmt.Errorf("invalid priority %q (allowed: %s)", p, strings.Join(allowedPriorities, ", "))
+		}
+		out.Priority = append(out.Priority, p)
+	}
+	for _, room := range f.Room {
+		if room = strings.TrimSpace(room); room != "" {
+			out.Room = append(out.Room, room)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// openTestDB points db at a fresh, migrated SQLite database for the length of the test
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevDialect := db, sqlDialect
+	db, sqlDialect = &timedDB{sqlDB}, sqliteDialect{}
+	t.Cleanup(func() {
+		sqlDB.Close()
+		db, sqlDialect = prevDB, prevDialect
+	})
+	if err := migrate(context.Background(), "sqlite"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestTicket stores t, with the defaults a client may omit, and returns the stored row
+func createTestTicket(t *testing.T, tk Ticket) Ticket {
+	t.Helper()
+	if tk.Name == "" && !tk.Anonymous {
+		tk.Name = "Budi"
+	}
+	if tk.Phone == "" && !tk.Anonymous {
+		tk.Phone = "+628123456789"
+	}
+	if tk.Room == "" {
+		tk.Room = "A1"
+	}
+	applyTicketDefaults(&tk)
+	stored, err := insertTicket(context.Background(), db, tk, nil, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+// serve runs h on a request for target with a JSON body, when not empty, and the path
+// wildcards given as name, value pairs
+func serve(h http.HandlerFunc, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// decodeBody decodes the JSON object w holds
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+	}
+	return m
+}
+
+// errorFields returns the code and the field errors of an error response
+func errorFields(t *testing.T, w *httptest.ResponseRecorder) (string, map[string]interface{}) {
+	t.Helper()
+	e, _ := decodeBody(t, w)["error"].(map[string]interface{})
+	code, _ := e["code"].(string)
+	fields, _ := e["fields"].(map[string]interface{})
+	return code, fields
+}
+
+// testToken signs a token for role with jwtSecret, valid for an hour
+func testToken(t *testing.T, role string) string {
+	t.Helper()
+	claims := Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "tester", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}, Role: role}
+	raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeJSON(t *testing.T) {
+	prev := maxBodyBytes
+	t.Cleanup(func() { maxBodyBytes = prev })
+	maxBodyBytes = 64
+	for _, tc := range []struct {
+		name, contentType, body string
+		status                  int
+		code                    string
+	}{
+		{"valid", "application/json", `{"name":"Budi"}`, http.StatusOK, ""},
+		{"charset", "application/json; charset=utf-8", `{"name":"Budi"}`, http.StatusOK, ""},
+		{"at the limit", "application/json", `{"name":"` + strings.Repeat("a", 64-len(`{"name":""}`)) + `"}`, http.StatusOK, ""},
+		{"oversized", "application/json", `{"name":"` + strings.Repeat("a", 64) + `"}`, http.StatusRequestEntityTooLarge, "payload_too_large"},
+		{"unknown field", "application/json", `{"nmae":"Budi"}`, http.StatusBadRequest, "invalid_json"},
+		{"malformed", "application/json", `{"name":`, http.StatusBadRequest, "invalid_json"},
+		{"empty", "application/json", ``, http.StatusBadRequest, "empty_body"},
+		{"not json", "text/plain", `{"name":"Budi"}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	} {
+		r := httptest.NewRequest("POST", "/api/tickets", strings.NewReader(tc.body))
+		r.Header.Set("Content-Type", tc.contentType)
+		w := httptest.NewRecorder()
+		var v struct {
+			Name string `json:"name"`
+		}
+		if decodeJSON(w, r, &v) {
+			w.WriteHeader(http.StatusOK)
+		}
+		if w.Code != tc.status {
+			t.Errorf("%s: %d, want %d", tc.name, w.Code, tc.status)
+			continue
+		}
+		if tc.code != "" {
+			if code, _ := errorFields(t, w); code != tc.code {
+				t.Errorf("%s: code %q, want %q", tc.name, code, tc.code)
+			}
+		}
+	}
+}
+
+func TestWriteHandlersRequireJSON(t *testing.T) {
+	openTestDB(t)
+	tk := createTestTicket(t, Ticket{Description: "AC bocor"})
+	id := fmt.Sprint(tk.ID)
+	for _, h := range []struct {
+		method  string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"POST", createTicketHandler, `{"name":"Budi","phone":"08123456789","room":"A1","description":"lampu mati"}`},
+		{"PUT", updateTicketHandler, `{"name":"Budi","phone":"08123456789","room":"A1","description":"lampu mati","status":"open","priority":"high"}`},
+		{"PATCH", patchTicketHandler, `{"priority":"high"}`},
+	} {
+		for _, tc := range []struct {
+			contentType, body string
+			status            int
+			code              string
+		}{
+			{"", h.body, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+			{"application/x-www-form-urlencoded", "name=Budi&room=A1", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+			{"application/xml", "<ticket><name>Budi</name></ticket>", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+			{"text/plain", h.body, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+			{"application/json-patch+json", `[{"op":"replace","path":"/priority","value":"high"}]`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+			{"application/json", "", http.StatusBadRequest, "empty_body"},
+			{"application/json; charset=utf-8", "", http.StatusBadRequest, "empty_body"},
+		} {
+			r := httptest.NewRequest(h.method, "/api/tickets/"+id, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			r.SetPathValue("id", id)
+			w := httptest.NewRecorder()
+			h.handler(w, r)
+			if code, _ := errorFields(t, w); w.Code != tc.status || code != tc.code {
+				t.Errorf("%s with Content-Type %q: %d %s, want %d %s", h.method, tc.contentType, w.Code, w.Body, tc.status, tc.code)
+			}
+		}
+	}
+	var n, version int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*), MAX(version) FROM tickets").Scan(&n, &version); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || version != tk.Version {
+		t.Errorf("refused bodies left %d tickets at version %d, want 1 at %d", n, version, tk.Version)
+	}
+}
+
+func TestCreateTicketBodyLimits(t *testing.T) {
+	openTestDB(t)
+	prev := maxBodyBytes
+	t.Cleanup(func() { maxBodyBytes = prev })
+	maxBodyBytes = 256
+	for _, tc := range []struct {
+		body   string
+		status int
+	}{
+		{`{"name":"Budi","phone":"08123456789","room":"A1","description":"` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge},
+		{`{"name":"Budi","phone":"08123456789","room":"A1","descripton":"typo"}`, http.StatusBadRequest},
+	} {
+		if w := serve(createTicketHandler, "POST", "/api/tickets", tc.body); w.Code != tc.status {
+			t.Errorf("POST %.40s...: %d, want %d", tc.body, w.Code, tc.status)
+		}
+	}
+	var n int
+	if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM tickets").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("%d tickets stored from rejected bodies", n)
+	}
+}
+
+func TestTicketJSONOmitsUnsetFields(t *testing.T) {
+	optional := []string{"assigned_to", "tags", "due_at", "deleted_at", "merged_into", "lat", "lng", "metadata", "public_id", "resolution", "resolved_at"}
+	minimal := Ticket{ID: 1, Name: "Budi", Phone: "+628123456789", Room: "A1", Status: "open", Priority: "medium", Version: 1, Tags: []string{}}
+	b, err := json.Marshal(minimal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range optional {
+		if _, ok := got[key]; ok {
+			t.Errorf("minimal ticket has %q: %s", key, b)
+		}
+	}
+	for _, key := range []string{"id", "name", "phone", "room", "description", "anonymous", "status", "priority", "version", "created_at", "updated_at"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("minimal ticket lacks %q: %s", key, b)
+		}
+	}
+
+	// set values are still sent, the zero ones included
+	agent, zero, into, now, resolution := "andi", 0.0, TicketID(2), time.Now(), "router diganti"
+	full := minimal
+	full.AssignedTo, full.Tags, full.DueAt, full.DeletedAt, full.MergedInto = &agent, []string{"wifi"}, &now, &now, &into
+	full.Lat, full.Lng, full.Metadata, full.PublicID = &zero, &zero, map[string]interface{}{"assetTag": "INV-1"}, "abc"
+	full.Resolution, full.ResolvedAt = &resolution, &now
+	if b, err = json.Marshal(full); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range optional {
+		if _, ok := got[key]; !ok {
+			t.Errorf("ticket with %s set does not send it: %s", key, b)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// errMergeTarget is returned inside the merge transaction when the ticket to merge into is missing
+var errMergeTarget = errors.New("ticket to merge into not found")
+
+// mergeTicketHandler serves POST /api/tickets/{id}/merge with {"into": 7}. The duplicate's comments
+// and attachments move to the target, its description is added there as a comment, and it is
+// closed with merged_into pointing at the target. Closing goes through the usual transition check,
+// with "merged into #7" as its reason. Responds with the target.
+func mergeTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var req struct {
+		Into TicketID `json:"into"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	switch {
+	case req.Into <= 0:
+		writeFieldErrors(w, FieldErrors{"into": "is required"})
+		return
+	case req.Into == id:
+		writeError(w, http.StatusBadRequest, "invalid_request", "cannot merge a ticket into itself")
+		return
+	}
+	actor := actorFromRequest(r)
+	reason := "merged into #" + strconv.FormatInt(int64(req.Into), 10)
+	var before, source, targetBefore, target Ticket
+	var c Comment
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		if before, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		if before.MergedInto != nil {
+			return &conflictError{"invalid_transition", "ticket is already merged into #" + strconv.FormatInt(int64(*before.MergedInto), 10), before}
+		}
+		if err := checkTransition(before.Status, "closed"); err != nil {
+			return &conflictError{"invalid_transition", err.Error(), before}
+		}
+		if _, err := checkStatusReason(before.Status, "closed", &reason); err != nil {
+			return err
+		}
+		targetBefore, err = loadTicket(ctx, tx, req.Into)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errMergeTarget
+		}
+		if err != nil {
+			return err
+		}
+		// merging into a merged ticket would leave the comments on a closed duplicate
+		if targetBefore.MergedInto != nil {
+			return &conflictError{"invalid_transition", "the ticket to merge into is itself merged into #" + strconv.FormatInt(int64(*targetBefore.MergedInto), 10), targetBefore}
+		}
+		for _, table := range []string{"comments", "attachments"} {
+			if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET ticket_id = ? WHERE ticket_id = ?", req.Into, id); err != nil {
+				return err
+			}
+		}
+		c = Comment{TicketID: req.Into, Author: actor, Body: fmt.Sprintf("Digabung dari tiket #%d: %s", id, before.Description)}
+		res, err := tx.ExecContext(ctx, "INSERT INTO comments (ticket_id, author, body) VALUES (?, ?, ?)", c.TicketID, c.Author, c.Body)
+		if err != nil {
+			return err
+		}
+		cid, _ := res.LastInsertId()
+		c.ID = int(cid)
+		if err := tx.QueryRowContext(ctx, "SELECT created_at FROM comments WHERE id = ?", cid).Scan(&c.CreatedAt); err != nil {
+			return err
+		}
+		inDisplayZone(&c.CreatedAt)
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET status = 'closed', merged_into = ?, version = version + 1 WHERE id = ?", req.Into, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET version = version + 1 WHERE id = ?", req.Into); err != nil {
+			return err
+		}
+		if source, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		if target, err = loadTicket(ctx, tx, req.Into); err != nil {
+			return err
+		}
+		if err := writeAuditReason(ctx, tx, id, "merge", actor, reason, &before, &source); err != nil {
+			return err
+		}
+		return writeAudit(ctx, tx, req.Into, "merge", actor, &targetBefore, &target)
+	})
+	if errors.Is(err, errMergeTarget) {
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+		return
+	}
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, target)
+	ticketsUpdated.Add(2)
+	broad.Broadcast("ticket_updated", source, updatedPayload(before, source, reason))
+	broad.Broadcast("ticket_updated", target, updatedPayload(targetBefore, target, ""))
+	broad.Broadcast("comment_added", target, map[string]interface{}{"ticket_id": req.Into, "comment": c})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMergeFollowsTransitions(t *testing.T) {
+	openTestDB(t)
+	target := createTestTicket(t, Ticket{})
+	merge := func(id TicketID) *httptest.ResponseRecorder {
+		return serve(mergeTicketHandler, "POST", "/api/tickets/x/merge", fmt.Sprintf(`{"into":%d}`, target.ID), "id", fmt.Sprint(id))
+	}
+
+	open := createTestTicket(t, Ticket{})
+	if w := merge(open.ID); w.Code != http.StatusConflict {
+		t.Fatalf("merging an open ticket: %d %s, want 409", w.Code, w.Body)
+	}
+	if code, _ := errorFields(t, merge(open.ID)); code != "invalid_transition" {
+		t.Errorf("merging an open ticket: code %q, want invalid_transition", code)
+	}
+
+	resolved := createTestTicket(t, Ticket{Status: "resolved"})
+	if w := merge(resolved.ID); w.Code != http.StatusOK {
+		t.Fatalf("merging a resolved ticket: %d %s", w.Code, w.Body)
+	}
+	var status, reason string
+	err := db.QueryRowContext(context.Background(), "SELECT t.status, a.reason FROM tickets t JOIN audit_log a ON a.ticket_id = t.id AND a.action = 'merge' WHERE t.id = ?", resolved.ID).Scan(&status, &reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("merged into #%d", target.ID); status != "closed" || reason != want {
+		t.Errorf("merged ticket: status %q, history reason %q; want closed, %q", status, reason, want)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import "net/http"
+
+// ticketMeta is the response of GET /api/meta: the enums and status rules the validation uses
+type ticketMeta struct {
+	Statuses   []string `json:"statuses"`
+	Priorities []string `json:"priorities"`
+	// Transitions lists where each status may move next with PUT or PATCH
+	Transitions map[string][]string `json:"transitions"`
+	// Reopenable are the statuses POST /api/tickets/{id}/reopen moves back to open
+	Reopenable []string `json:"reopenable"`
+	// ReasonRequired are the statuses a change into needs a status_reason
+	ReasonRequired []string `json:"reason_required"`
+}
+
+// metaHandler serves GET /api/meta, so clients don't hardcode the statuses and transitions
+func metaHandler(w http.ResponseWriter, r *http.Request) {
+	m := ticketMeta{
+		Statuses:       allowedStatuses,
+		Priorities:     allowedPriorities,
+		Transitions:    map[string][]string{},
+		Reopenable:     reopenableStatuses,
+		ReasonRequired: reasonRequiredStatuses,
+	}
+	// unknownEnum is only a placeholder for dirty rows, not a status a client can set
+	for _, s := range allowedStatuses {
+		m.Transitions[s] = append([]string{}, statusTransitions[s]...)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, m)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxMetadataBytes caps a ticket's custom fields, measured as encoded JSON
+const maxMetadataBytes = 4096
+
+// metadataKey is what a custom field may be named: safe inside a JSON path without quoting
+var metadataKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// metadataFilterKeys are the custom fields ?metadata.<key>= may filter on (-metadata-filter-keys)
+var metadataFilterKeys []string
+
+// parseMetadataFilterKeys splits the -metadata-filter-keys flag, checking every key
+func parseMetadataFilterKeys(raw string) ([]string, error) {
+	var keys []string
+	for _, k := range strings.Split(raw, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if !metadataKey.MatchString(k) {
+			return nil, fmt.Errorf("invalid metadata key %q", k)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// validateMetadata checks the custom fields of a ticket: valid names and a bounded size
+func validateMetadata(m map[string]interface{}) FieldErrors {
+	errs := FieldErrors{}
+	for k := range m {
+		if !metadataKey.MatchString(k) {
+			errs["metadata"] = fmt.Sprintf("key %q must start with a letter and have at most 64 letters, digits or _", k)
+			return errs
+		}
+	}
+	if b, err := json.Marshal(m); err != nil || len(b) > maxMetadataBytes {
+		errs["metadata"] = fmt.Sprintf("must be at most %d bytes as JSON", maxMetadataBytes)
+	}
+	return errs
+}
+
+// metadataValue is m as stored in tickets.metadata; nil for a nil map, so COALESCE keeps the column
+func metadataValue(m map[string]interface{}) interface{} {
+	if m == nil {
+		return nil
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// metadataColumn scans tickets.metadata into a map, leaving it nil for NULL
+type metadataColumn struct{ m *map[string]interface{} }
+
+func (c metadataColumn) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*c.m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("metadata: unexpected %T", src)
+	}
+	return json.Unmarshal(b, c.m)
+}
+
+// filterMetadata adds a condition for each ?metadata.<key>=value, matching the value as text.
+// Only keys in metadataFilterKeys are accepted, so the JSON path never comes from the client.
+func (f *ticketFilter) filterMetadata(r *http.Request) error {
+	params := make([]string, 0)
+	for p := range r.URL.Query() {
+		if strings.HasPrefix(p, "metadata.") {
+			params = append(params, p)
+		}
+	}
+	slices.Sort(params)
+	for _, p := range params {
+		key := strings.TrimPrefix(p, "metadata.")
+		i := slices.Index(metadataFilterKeys, key)
+		if i < 0 {
+			allowed := strings.Join(metadataFilterKeys, ", ")
+			if allowed == "" {
+				allowed = "none, see -metadata-filter-keys"
+			}
+			return fmt.Errorf("cannot filter on metadata key %q (allowed: %s)", key, allowed)
+		}
+		f.conds = append(f.conds, sqlDialect.JSONText("metadata")+" = ?")
+		f.args = append(f.args, "$."+metadataFilterKeys[i], r.URL.Query().Get(p))
+	}
+	return nil
+}
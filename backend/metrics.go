@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ticketsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tickets_created_total",
+		Help: "Tickets created.",
+	})
+	ticketsUpdated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tickets_updated_total",
+		Help: "Ticket updates, including assignments and bulk status changes.",
+	})
+	ticketsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tickets_deleted_total",
+		Help: "Tickets deleted.",
+	})
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "websocket_connections",
+		Help: "Admin websocket connections currently open.",
+	})
+	wsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "websocket_dropped_connections_total",
+		Help: "Admin websocket connections dropped by the server, by reason.",
+	}, []string{"reason"})
+	eventStoreFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "broadcast_event_store_failures_total",
+		Help: "Broadcasts that could not be stored in broadcast_events, sent without a seq and not replayable.",
+	})
+	criticalUnacked = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "critical_alerts_unacknowledged_total",
+		Help: "ticket_critical alerts no client acknowledged within -ack-window.",
+	})
+	wsRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_rejected_connections_total",
+		Help: "Admin websocket connections refused because -max-ws-connections were open.",
+	})
+	wsCoalesced = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "websocket_coalesced_updates_total",
+		Help: "ticket_updated broadcasts replaced by a later update of the same ticket before being sent.",
+	})
+	wsFailedWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "websocket_failed_writes_total",
+		Help: "Failed websocket writes, by reason (write_error or deadline_exceeded).",
+	}, []string{"reason"})
+	dbErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "db_errors_total",
+		Help: "Database errors returned to clients.",
+	})
+	dbSlowQueries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "db_slow_queries_total",
+		Help: "Statements that took -slow-query-ms or longer.",
+	})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route pattern and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "status"})
+)
+
+// statusRecorder remembers the status code written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the Flusher underneath, for SSE streams
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// Hijack lets websocket upgrades through the recorder
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// metricsMiddleware observes request durations, labelled by the ServeMux pattern that matched
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.WithLabelValues(route, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
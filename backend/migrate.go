@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+)
+
+// migrationFiles holds one directory of .sql files per -db-driver, applied in file name order.
+// A statement ends with a semicolon at the end of a line, so keep trigger bodies on one line.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrate applies the migrations for driver not yet recorded in schema_migrations, each in its
+// own transaction. MySQL commits DDL implicitly, so a migration failing halfway there has to be
+// cleaned up by hand before the next boot retries it.
+func migrate(ctx context.Context, driver string) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(255) NOT NULL PRIMARY KEY,
+		applied_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+	applied := map[string]bool{}
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dir := path.Join("migrations", driver)
+	names, err := fs.Glob(migrationFiles, dir+"/*.sql")
+	if err != nil {
+		return err
+	}
+	ran := 0
+	for _, name := range names { // fs.Glob returns them sorted
+		version := strings.TrimSuffix(path.Base(name), ".sql")
+		if applied[version] {
+			continue
+		}
+		body, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		err = inTx(ctx, func(tx *timedTx) error {
+			for _, stmt := range splitStatements(string(body)) {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES (?)", version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+		log.Printf("db migration applied: %s", version)
+		ran++
+	}
+	if ran == 0 {
+		log.Printf("db schema up to date (%d migrations)", len(applied))
+	}
+	return nil
+}
+
+// splitStatements cuts a migration file into statements at lines ending with a semicolon,
+// dropping chunks that hold only comments and blank lines
+func splitStatements(body string) []string {
+	var stmts []string
+	var cur strings.Builder
+	hasSQL := false
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+			hasSQL = true
+		}
+		cur.WriteString(line)
+		cur.WriteByte('\n')
+		if strings.HasSuffix(trimmed, ";") && hasSQL {
+			stmts = append(stmts, strings.TrimSpace(cur.String()))
+			cur.Reset()
+			hasSQL = false
+		}
+	}
+	if hasSQL {
+		stmts = append(stmts, strings.TrimSpace(cur.String()))
+	}
+	return stmts
+}
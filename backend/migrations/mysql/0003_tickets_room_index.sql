@@ -0,0 +1,2 @@
+-- lets GET /api/rooms read the distinct rooms from the index
+CREATE INDEX `idx_tickets_room` ON `tickets` (`room`);
@@ -0,0 +1,2 @@
+-- set on a duplicate by POST /api/tickets/{id}/merge
+ALTER TABLE `tickets` ADD COLUMN `merged_into` int DEFAULT NULL;
@@ -0,0 +1,4 @@
+-- map pins for tickets (lat/lng), searched by GET /api/tickets/geo
+ALTER TABLE `tickets` ADD COLUMN `lat` double DEFAULT NULL;
+ALTER TABLE `tickets` ADD COLUMN `lng` double DEFAULT NULL;
+CREATE INDEX `idx_tickets_lat_lng` ON `tickets` (`lat`, `lng`);
@@ -0,0 +1,12 @@
+-- every broadcast with its seq, so the replay window and the numbering survive a restart;
+-- only the newest are kept
+CREATE TABLE IF NOT EXISTS `broadcast_events` (
+  `seq` bigint unsigned NOT NULL,
+  `event` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `ticket_id` int NOT NULL DEFAULT 0,
+  `room` varchar(100) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `priority` varchar(20) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `payload` mediumtext COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,13 @@
+-- who received each ticket_critical alert: one row per connection acknowledging its ack_id
+CREATE TABLE IF NOT EXISTS `ticket_acks` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `ticket_id` int NOT NULL,
+  `ack_id` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `connection_id` varchar(36) COLLATE utf8mb4_general_ci NOT NULL,
+  `subject` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `acked_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_ticket_acks_connection` (`ack_id`, `connection_id`),
+  KEY `idx_ticket_acks_ticket` (`ticket_id`, `acked_at`),
+  CONSTRAINT `fk_ticket_acks_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
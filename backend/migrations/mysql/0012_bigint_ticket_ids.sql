@@ -0,0 +1,18 @@
+-- ticket ids become BIGINT, matching the int64 TicketID. The foreign keys must go while both
+-- ends change type and are added back afterwards.
+ALTER TABLE `comments` DROP FOREIGN KEY `fk_comments_ticket`;
+ALTER TABLE `ticket_tags` DROP FOREIGN KEY `fk_ticket_tags_ticket`;
+ALTER TABLE `attachments` DROP FOREIGN KEY `fk_attachments_ticket`;
+ALTER TABLE `ticket_acks` DROP FOREIGN KEY `fk_ticket_acks_ticket`;
+ALTER TABLE `tickets` MODIFY `id` bigint NOT NULL AUTO_INCREMENT, MODIFY `merged_into` bigint DEFAULT NULL;
+ALTER TABLE `comments` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `ticket_tags` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `audit_log` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `attachments` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `idempotency_keys` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `broadcast_events` MODIFY `ticket_id` bigint NOT NULL DEFAULT 0;
+ALTER TABLE `ticket_acks` MODIFY `ticket_id` bigint NOT NULL;
+ALTER TABLE `comments` ADD CONSTRAINT `fk_comments_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE;
+ALTER TABLE `ticket_tags` ADD CONSTRAINT `fk_ticket_tags_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE;
+ALTER TABLE `attachments` ADD CONSTRAINT `fk_attachments_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE;
+ALTER TABLE `ticket_acks` ADD CONSTRAINT `fk_ticket_acks_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE;
@@ -0,0 +1,3 @@
+-- the database numbers broadcasts, so instances sharing it never hand out the same seq; the
+-- counter is not reset by deleting rows, so a number a client has seen is never reused
+ALTER TABLE `broadcast_events` MODIFY `seq` bigint unsigned NOT NULL AUTO_INCREMENT;
@@ -0,0 +1,2 @@
+-- backs the -max-open-per-phone count
+CREATE INDEX IF NOT EXISTS idx_tickets_phone_status ON tickets (phone, status);
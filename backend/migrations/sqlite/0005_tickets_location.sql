@@ -0,0 +1,4 @@
+-- map pins for tickets (lat/lng), searched by GET /api/tickets/geo
+ALTER TABLE tickets ADD COLUMN lat REAL DEFAULT NULL;
+ALTER TABLE tickets ADD COLUMN lng REAL DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS idx_tickets_lat_lng ON tickets (lat, lng);
@@ -0,0 +1,4 @@
+-- random id for the public single-ticket lookup, GET /api/public/tickets/{public_id}
+ALTER TABLE tickets ADD COLUMN public_id TEXT DEFAULT NULL;
+UPDATE tickets SET public_id = lower(hex(randomblob(16))) WHERE public_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tickets_public_id ON tickets (public_id);
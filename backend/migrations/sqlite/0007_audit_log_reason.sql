@@ -0,0 +1,2 @@
+-- why a status changed, from status_reason on PUT/PATCH; kept in the history only
+ALTER TABLE audit_log ADD COLUMN reason TEXT DEFAULT NULL;
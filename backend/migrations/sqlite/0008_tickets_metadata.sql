@@ -0,0 +1,2 @@
+-- custom fields per department, e.g. {"assetTag": "INV-0042"}; filtered with ?metadata.<key>=
+ALTER TABLE tickets ADD COLUMN metadata TEXT DEFAULT NULL;
@@ -0,0 +1,11 @@
+-- every broadcast with its seq, so the replay window and the numbering survive a restart;
+-- only the newest are kept
+CREATE TABLE IF NOT EXISTS broadcast_events (
+  seq INTEGER PRIMARY KEY,
+  event TEXT NOT NULL,
+  ticket_id INTEGER NOT NULL DEFAULT 0,
+  room TEXT NOT NULL DEFAULT '',
+  priority TEXT NOT NULL DEFAULT '',
+  payload TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- how a ticket was resolved and when, set by POST /api/tickets/{id}/resolve and cleared on reopen
+ALTER TABLE tickets ADD COLUMN resolution TEXT DEFAULT NULL;
+ALTER TABLE tickets ADD COLUMN resolved_at TIMESTAMP DEFAULT NULL;
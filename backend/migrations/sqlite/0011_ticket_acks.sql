@@ -0,0 +1,11 @@
+-- who received each ticket_critical alert: one row per connection acknowledging its ack_id
+CREATE TABLE IF NOT EXISTS ticket_acks (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  ticket_id INTEGER NOT NULL REFERENCES tickets (id) ON DELETE CASCADE,
+  ack_id TEXT NOT NULL,
+  connection_id TEXT NOT NULL,
+  subject TEXT NOT NULL DEFAULT '',
+  acked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (ack_id, connection_id)
+);
+CREATE INDEX IF NOT EXISTS idx_ticket_acks_ticket ON ticket_acks (ticket_id, acked_at);
@@ -0,0 +1,2 @@
+-- SQLite integers are already 64 bit, so there is nothing to run; the file keeps both drivers
+-- at the same version as the MySQL migration that widens ticket ids to BIGINT
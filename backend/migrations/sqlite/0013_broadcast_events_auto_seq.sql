@@ -0,0 +1,16 @@
+-- the database numbers broadcasts, so instances sharing it never hand out the same seq.
+-- AUTOINCREMENT keeps SQLite from reusing the number of the newest row once it is deleted;
+-- it can only be set when the table is created, hence the copy.
+CREATE TABLE broadcast_events_new (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  event TEXT NOT NULL,
+  ticket_id INTEGER NOT NULL DEFAULT 0,
+  room TEXT NOT NULL DEFAULT '',
+  priority TEXT NOT NULL DEFAULT '',
+  payload TEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO broadcast_events_new (seq, event, ticket_id, room, priority, payload, created_at)
+  SELECT seq, event, ticket_id, room, priority, payload, created_at FROM broadcast_events;
+DROP TABLE broadcast_events;
+ALTER TABLE broadcast_events_new RENAME TO broadcast_events;
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// smtpNotifier emails the on-call team about important tickets. Without a host it is a no-op.
+type smtpNotifier struct {
+	host string // host:port
+	user string
+	pass string
+	to   []string
+}
+
+var notifier smtpNotifier
+
+func (n smtpNotifier) enabled() bool {
+	return n.host != "" && len(n.to) > 0
+}
+
+// NotifyTicket sends one email describing t
+func (n smtpNotifier) NotifyTicket(t Ticket) error {
+	return n.send(fmt.Sprintf("[PUSTIK] Tiket %s #%d - %s", t.Priority, t.ID, t.Room), t)
+}
+
+// NotifyUnacked emails t again because nobody acknowledged its ticket_critical alert within window
+func (n smtpNotifier) NotifyUnacked(t Ticket, window time.Duration) error {
+	return n.send(fmt.Sprintf("[PUSTIK] BELUM DITANGGAPI %s: Tiket %s #%d - %s", window, t.Priority, t.ID, t.Room), t)
+}
+
+// send emails t under subject
+func (n smtpNotifier) send(subject string, t Ticket) error {
+	var auth smtp.Auth
+	if n.user != "" {
+		hostname, _, err := net.SplitHostPort(n.host)
+		if err != nil {
+			hostname = n.host
+		}
+		auth = smtp.PlainAuth("", n.user, n.pass, hostname)
+	}
+	from := n.user
+	if from == "" {
+		from = "helpdesk@localhost"
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Nama: %s\r\nRuangan: %s\r\nTelepon: %s\r\nPrioritas: %s\r\n\r\n%s\r\n",
+		t.Name, t.Room, t.Phone, t.Priority, t.Description)
+	return smtp.SendMail(n.host, auth, from, n.to, []byte(msg.String()))
+}
+
+// notifyIfImportant emails high and urgent tickets in the background; failures are only logged.
+// Critical tickets are left to escalateIfCritical, which also covers escalations.
+func notifyIfImportant(t Ticket) {
+	if t.Priority != "high" && t.Priority != "urgent" {
+		return
+	}
+	notifyNow(t)
+}
+
+// notifyNow emails t in the background when a notifier is configured; failures are only logged
+func notifyNow(t Ticket) {
+	if !notifier.enabled() {
+		return
+	}
+	go func() {
+		if err := notifier.NotifyTicket(t); err != nil {
+			log.Printf("notify ticket %d: %v", t.ID, err)
+		}
+	}()
+}
+
+// notifyLevels maps each priority to the "notify" hint of ticket_created and ticket_critical
+// broadcasts (-notify-levels), so dashboards pick an alert sound without their own mapping
+var notifyLevels = map[string]string{"low": "info", "medium": "info", "high": "warn", "urgent": "warn", "critical": "critical"}
+
+// notifyHintLevels are the hints -notify-levels may use
+var notifyHintLevels = []string{"info", "warn", "critical"}
+
+// parseNotifyLevels parses "low:info,high:warn" over the defaults of notifyLevels
+func parseNotifyLevels(raw string) (map[string]string, error) {
+	levels := maps.Clone(notifyLevels)
+	for _, pair := range strings.Split(raw, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		priority, level, ok := strings.Cut(pair, ":")
+		priority, level = strings.TrimSpace(priority), strings.TrimSpace(level)
+		if !ok || !slices.Contains(allowedPriorities, priority) || !slices.Contains(notifyHintLevels, level) {
+			return nil, fmt.Errorf("invalid pair %q (want priority:level, priorities %s, levels %s)",
+				pair, strings.Join(allowedPriorities, ", "), strings.Join(notifyHintLevels, ", "))
+		}
+		levels[priority] = level
+	}
+	return levels, nil
+}
+
+// notifyHint is the "notify" of a broadcast: the level of about's priority for the events a
+// dashboard alerts on, empty for the rest
+func notifyHint(event string, about Ticket) string {
+	if event != "ticket_created" && event != "ticket_critical" {
+		return ""
+	}
+	return notifyLevels[about.Priority]
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// obj keeps the spec literals below readable
+type obj = map[string]interface{}
+
+// openAPIComponents are the types published under #/components/schemas; their properties are
+// derived from the struct fields so the spec follows the Go types
+var openAPIComponents = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Ticket", reflect.TypeOf(Ticket{})},
+	{"TicketPage", reflect.TypeOf(TicketPage{})},
+	{"TicketStats", reflect.TypeOf(TicketStats{})},
+	{"Comment", reflect.TypeOf(Comment{})},
+	{"Attachment", reflect.TypeOf(Attachment{})},
+	{"AuditEntry", reflect.TypeOf(AuditEntry{})},
+	{"TicketDetail", reflect.TypeOf(TicketDetail{})},
+	{"TicketPatch", reflect.TypeOf(ticketPatch{})},
+	{"ConnectionInfo", reflect.TypeOf(ConnectionInfo{})},
+	{"TicketMeta", reflect.TypeOf(ticketMeta{})},
+	{"ImportSummary", reflect.TypeOf(importSummary{})},
+	{"ImportRowError", reflect.TypeOf(importRowError{})},
+	{"TicketAck", reflect.TypeOf(TicketAck{})},
+}
+
+// openAPIEnums and openAPIReadOnly refine derived properties, keyed by "Type.json_name"
+var (
+	openAPIEnums = map[string][]string{
+		"Ticket.status":   allowedStatuses,
+		"Ticket.priority": allowedPriorities,
+	}
+	openAPIReadOnly = map[string]bool{
+		"Ticket.id": true, "Ticket.due_at": true, "Ticket.created_at": true, "Ticket.updated_at": true, "Ticket.deleted_at": true,
+		"Ticket.merged_into": true, "Ticket.public_id": true, "Ticket.resolution": true, "Ticket.resolved_at": true,
+	}
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema maps a Go type to a JSON schema, referring to components by name
+func typeSchema(t reflect.Type) obj {
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+	var s obj
+	switch {
+	case t == timeType:
+		s = obj{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		s = obj{"description": "arbitrary JSON"}
+	case t == reflect.TypeOf(TicketID(0)):
+		s = obj{"type": "integer", "format": "int64", "description": "a string of digits with -string-ids; requests accept either"}
+	default:
+		for _, c := range openAPIComponents {
+			if c.typ == t {
+				return obj{"$ref": "#/components/schemas/" + c.name}
+			}
+		}
+		switch t.Kind() {
+		case reflect.String:
+			s = obj{"type": "string"}
+		case reflect.Int, reflect.Int64, reflect.Int32:
+			s = obj{"type": "integer"}
+		case reflect.Float64, reflect.Float32:
+			s = obj{"type": "number"}
+		case reflect.Bool:
+			s = obj{"type": "boolean"}
+		case reflect.Slice:
+			s = obj{"type": "array", "items": typeSchema(t.Elem())}
+		case reflect.Map:
+			s = obj{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+		case reflect.Interface:
+			s = obj{"description": "arbitrary JSON"}
+		default:
+			s = obj{"type": "object"}
+		}
+	}
+	if nullable {
+		s["nullable"] = true
+	}
+	return s
+}
+
+// structSchema lists the JSON properties of struct t, skipping fields tagged "-"
+func structSchema(name string, t reflect.Type) obj {
+	props := obj{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		p := typeSchema(f.Type)
+		if enum, ok := openAPIEnums[name+"."+tag]; ok {
+			p["enum"] = enum
+		}
+		if openAPIReadOnly[name+"."+tag] {
+			p["readOnly"] = true
+		}
+		if strings.Contains(opts, "omitempty") {
+			p["description"] = "omitted when empty"
+		}
+		props[tag] = p
+	}
+	return obj{"type": "object", "properties": props}
+}
+
+func ref(name string) obj { return obj{"$ref": "#/components/schemas/" + name} }
+
+func jsonContent(schema obj) obj { return obj{"application/json": obj{"schema": schema}} }
+
+func response(desc string, schema obj) obj {
+	if schema == nil {
+		return obj{"description": desc}
+	}
+	return obj{"description": desc, "content": jsonContent(schema)}
+}
+
+// errorResponse is how writeError answers
+func errorResponse(desc string) obj {
+	return response(desc, ref("Error"))
+}
+
+// eventStream is a text/event-stream response; each event's data is the broadcast payload as JSON
+var eventStream = obj{"description": "event stream", "content": obj{"text/event-stream": obj{"schema": obj{"type": "string"}}}}
+
+// withHeader documents a response header on resp
+func withHeader(resp obj, name, desc string) obj {
+	resp["headers"] = obj{name: obj{"description": desc, "schema": obj{"type": "string"}}}
+	return resp
+}
+
+func queryParam(name, desc string, schema obj) obj {
+	return obj{"name": name, "in": "query", "description": desc, "schema": schema}
+}
+
+var idParam = obj{"name": "id", "in": "path", "required": true, "schema": obj{"type": "integer", "format": "int64", "minimum": 1}}
+
+// uploadBody is the multipart body of both attachment uploads
+var uploadBody = obj{"required": true, "content": obj{"multipart/form-data": obj{"schema": obj{"type": "object", "properties": obj{"file": obj{"type": "string", "format": "binary"}}}}}}
+
+var (
+	adminOnly  = []obj{{"bearerAuth": []string{}}}
+	listParams = []obj{
+		queryParam("page", "page number, from 1", obj{"type": "integer", "minimum": 1, "default": 1}),
+		queryParam("per_page", "page size, at most 200", obj{"type": "integer", "minimum": 1, "maximum": maxPerPage, "default": defaultPerPage}),
+		queryParam("status", "comma separated statuses", obj{"type": "string"}),
+		queryParam("priority", "comma separated priorities", obj{"type": "string"}),
+		queryParam("room", "comma separated rooms", obj{"type": "string"}),
+		queryParam("tag", "comma separated tags, any of them matches", obj{"type": "string"}),
+		queryParam("created_after", "inclusive lower bound on created_at", obj{"type": "string", "format": "date-time"}),
+		queryParam("created_before", "inclusive upper bound on created_at", obj{"type": "string", "format": "date-time"}),
+		queryParam("sort", "created_at, updated_at, priority or status; prefix - for descending. Ties are broken by id in the same direction. Without it, -default-sort applies", obj{"type": "string", "default": "-created_at"}),
+		queryParam("include_deleted", "also list soft-deleted tickets (admins only)", obj{"type": "boolean"}),
+	}
+)
+
+// buildOpenAPI assembles the OpenAPI 3.0 document served at /api/openapi.json
+func buildOpenAPI() obj {
+	schemas := obj{
+		"ApiError": obj{"type": "object", "required": []string{"code", "message"}, "properties": obj{
+			"code": obj{"type": "string", "description": "stable, for clients to branch on: invalid_id, invalid_json, empty_body, invalid_request, " +
+				"invalid_parameter, invalid_header, invalid_signature, unauthorized, origin_not_allowed, not_found, validation_failed, " +
+				"version_conflict, invalid_transition, ticket_not_closed, payload_too_large, unsupported_media_type, rate_limited, " +
+				"too_many_open_tickets, too_many_connections, forbidden, database_timeout, internal_error"},
+			"message": obj{"type": "string", "description": "for humans, may change"},
+			"fields":  obj{"type": "object", "additionalProperties": obj{"type": "string"}, "description": "problem per JSON field, with validation_failed"},
+			"items": obj{"type": "array", "description": "invalid elements of a batch request", "items": obj{"type": "object", "properties": obj{
+				"index":  obj{"type": "integer"},
+				"fields": obj{"type": "object", "additionalProperties": obj{"type": "string"}},
+			}}},
+		}},
+		"Error": obj{"type": "object", "properties": obj{"error": ref("ApiError")}},
+		"ValidationError": obj{"type": "object", "description": "an Error with code validation_failed and fields set", "properties": obj{
+			"error": ref("ApiError"),
+		}},
+		"Conflict": obj{"type": "object", "properties": obj{
+			"error":   ref("ApiError"),
+			"current": ref("Ticket"),
+		}},
+	}
+	for _, c := range openAPIComponents {
+		schemas[c.name] = structSchema(c.name, c.typ)
+	}
+	ticketBody := obj{"required": true, "content": jsonContent(ref("Ticket"))}
+	statusReason := obj{"type": "string", "maxLength": maxCommentLen,
+		"description": "why the status changes, kept in the history; required when it changes to " + strings.Join(reasonRequiredStatuses, " or ")}
+	page := response("one page of tickets", ref("TicketPage"))
+
+	paths := obj{
+		"/api/tickets": obj{
+			"get": obj{"summary": "List tickets; ?metadata.<key>=value also filters on the custom fields named in -metadata-filter-keys",
+				"parameters": append([]obj{{"name": "If-Modified-Since", "in": "header", "description": "with -list-last-modified, the Last-Modified of an earlier response", "schema": obj{"type": "string"}}}, listParams...),
+				"responses": obj{
+					"200": withHeader(response("one page of tickets", ref("TicketPage")), "Last-Modified", "with -list-last-modified, the newest updated_at in the result"),
+					"304": obj{"description": "no ticket in the result was updated since If-Modified-Since"},
+					"400": errorResponse("invalid filter"),
+				}},
+			"post": obj{
+				"summary": "Create a ticket",
+				"parameters": []obj{
+					{"name": "Idempotency-Key", "in": "header", "description": "repeat within 24h to get the original ticket back", "schema": obj{"type": "string", "maxLength": maxIdempotencyKeyLen}},
+					queryParam("check_duplicates", "answer 200 with similar open tickets of the same room instead of creating", obj{"type": "boolean"}),
+					queryParam("force", "with check_duplicates, create anyway", obj{"type": "boolean"}),
+				},
+				"requestBody": ticketBody,
+				"responses": obj{
+					"200": response("possible duplicates, nothing created (only with check_duplicates=true). With -public-ids-only, callers without a token only get their room, status, created_at and similarity", obj{"type": "object", "properties": obj{
+						"possible_duplicates": obj{"type": "array", "items": obj{"allOf": []obj{ref("Ticket"), {"type": "object", "properties": obj{"similarity": obj{"type": "number", "minimum": 0, "maximum": 1}}}}}},
+					}}),
+					"201": response("created ticket, also returned for a repeated Idempotency-Key", ref("Ticket")),
+					"413": errorResponse("body too large"),
+					"422": response("validation failed", ref("ValidationError")),
+					"429": errorResponse("rate limited (see Retry-After), or too many unresolved tickets for this phone"),
+				},
+			},
+		},
+		"/api/tickets/validate": obj{
+			"post": obj{"summary": "Run the checks of ticket creation without creating anything", "requestBody": ticketBody,
+				"responses": obj{
+					"200": response("the ticket would be accepted", obj{"type": "object", "properties": obj{"valid": obj{"type": "boolean"}}}),
+					"422": response("validation failed", ref("ValidationError")),
+					"429": errorResponse("too many unresolved tickets for this phone"),
+				}},
+		},
+		"/api/tickets/email": obj{
+			"post": obj{"summary": "Open a ticket from an inbound email webhook (only when -email-webhook-secret is set)",
+				"parameters": []obj{{"name": emailSignatureHeader, "in": "header", "required": true, "description": "sha256=<hex HMAC-SHA256 of the raw body>", "schema": obj{"type": "string"}}},
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "properties": obj{
+					"from":    obj{"type": "string"},
+					"subject": obj{"type": "string"},
+					"body":    obj{"type": "string"},
+				}})},
+				"responses": obj{"201": response("created ticket", ref("Ticket")), "401": errorResponse("bad signature")}},
+		},
+		"/api/tickets/search": obj{
+			"get": obj{"summary": "Search name, room and description", "parameters": append([]obj{{"name": "q", "in": "query", "required": true, "schema": obj{"type": "string"}}}, listParams...),
+				"responses": obj{"200": page, "400": errorResponse("missing q or invalid filter")}},
+		},
+		"/api/tickets/overdue": obj{
+			"get": obj{"summary": "Unresolved tickets past their SLA deadline", "parameters": listParams, "responses": obj{"200": page}},
+		},
+		"/api/tickets/stats": obj{
+			"get": obj{"summary": "Dashboard counts, cached for a few seconds", "responses": obj{"200": response("summary", ref("TicketStats"))}},
+		},
+		"/api/tickets/geo": obj{
+			"get": obj{"summary": "Pinned tickets inside a bounding box, for the campus map",
+				"parameters": append([]obj{{"name": "bbox", "in": "query", "required": true, "description": "minLng,minLat,maxLng,maxLat; minLng above maxLng crosses the antimeridian", "schema": obj{"type": "string"}}}, listParams...),
+				"responses":  obj{"200": response("page of tickets", ref("TicketPage")), "400": errorResponse("invalid bbox or filter")}},
+		},
+		"/api/tickets/feed.xml": obj{
+			"get": obj{"summary": "Atom feed of the newest tickets, for feed readers; reporters of anonymous tickets are hidden for non-admins",
+				// the filters of listParams; the feed has no pages and is always newest first
+				"parameters": append(append([]obj{}, listParams[2:8]...), listParams[9]),
+				"responses": obj{
+					"200": obj{"description": "Atom feed of at most -feed-size tickets", "content": obj{"application/atom+xml": obj{"schema": obj{"type": "string"}}}},
+					"400": errorResponse("invalid filter"),
+				}},
+		},
+		"/api/tickets/stream": obj{
+			"get": obj{"summary": "Server-Sent Events carrying every websocket broadcast; Last-Event-ID replays missed ones", "security": adminOnly,
+				"parameters": []obj{
+					queryParam("priority", "comma separated priorities", obj{"type": "string"}),
+					queryParam("room", "comma separated rooms", obj{"type": "string"}),
+				},
+				"responses": obj{"200": eventStream, "400": errorResponse("invalid filter"), "401": errorResponse("unauthorized")}},
+		},
+		"/api/tickets/batch": obj{
+			"post": obj{"summary": "Import many tickets at once; if any is invalid none is created", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "array", "items": ref("Ticket"), "maxItems": maxBatchTickets})},
+				"responses": obj{
+					"201": response("created tickets, in request order", obj{"type": "array", "items": ref("Ticket")}),
+					"400": errorResponse("empty or too many tickets, or invalid json"),
+					"422": response("validation failed; error.items lists the invalid tickets by index", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/import.csv": obj{
+			"post": obj{"summary": "Import tickets from a CSV, inserting the valid rows and reporting the invalid ones by line", "security": adminOnly,
+				"description": "The header row names the columns: name, phone, room and description are required; anonymous, status, priority, " +
+					"assigned_to, tags (comma separated in one cell), lat and lng are optional.",
+				"requestBody": obj{"required": true, "content": obj{
+					"text/csv":            obj{"schema": obj{"type": "string"}},
+					"multipart/form-data": obj{"schema": obj{"type": "object", "required": []string{"file"}, "properties": obj{"file": obj{"type": "string", "format": "binary"}}}},
+				}},
+				"responses": obj{
+					"200": response("what was inserted and which rows were not", ref("ImportSummary")),
+					"400": errorResponse("empty CSV or invalid header row"),
+					"401": errorResponse("unauthorized"),
+					"415": errorResponse("neither text/csv nor multipart/form-data"),
+				}},
+		},
+		"/api/tickets/bulk": obj{
+			"patch": obj{"summary": "Set the status of many tickets", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "properties": obj{
+					"ids":           obj{"type": "array", "items": obj{"type": "integer"}, "maxItems": maxBulkIDs},
+					"status":        obj{"type": "string", "enum": allowedStatuses},
+					"status_reason": statusReason,
+				}})},
+				"responses": obj{
+					"200": response("result", obj{"type": "object", "properties": obj{
+						"updated":   obj{"type": "integer"},
+						"not_found": obj{"type": "array", "items": obj{"type": "integer"}},
+					}}),
+					"409": errorResponse("a ticket cannot move to that status"),
+					"422": response("status_reason missing", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/{id}": obj{
+			"parameters": []obj{idParam},
+			"get": obj{"summary": "Get a ticket; send its ETag as If-None-Match to get 304 while it is unchanged. With -public-ids-only it answers 404 without a token",
+				"parameters": []obj{{"name": "If-None-Match", "in": "header", "schema": obj{"type": "string"}}},
+				"responses": obj{
+					"200": withHeader(response("the ticket", ref("Ticket")), "ETag", "hash of the response body"),
+					"304": obj{"description": "not modified"},
+					"404": errorResponse("not found"),
+				}},
+			"put": obj{"summary": "Update a ticket; send the version you edited", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"allOf": []obj{ref("Ticket"),
+					{"type": "object", "properties": obj{"status_reason": statusReason}}}})},
+				"responses": obj{
+					"200": response("updated ticket", ref("Ticket")),
+					"404": errorResponse("not found"),
+					"409": response("stale version or disallowed status change", ref("Conflict")),
+					"422": response("validation failed", ref("ValidationError")),
+				}},
+			"patch": obj{"summary": "Change only the fields sent; version is optional", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(ref("TicketPatch"))},
+				"responses": obj{
+					"200": response("updated ticket", ref("Ticket")),
+					"400": errorResponse("empty patch or invalid json"),
+					"404": errorResponse("not found"),
+					"409": response("stale version or disallowed status change", ref("Conflict")),
+					"422": response("validation failed", ref("ValidationError")),
+				}},
+			"delete": obj{"summary": "Soft-delete a ticket; tickets that are not closed need ?force=true", "security": adminOnly,
+				"parameters": []obj{queryParam("force", "required to delete a ticket that is not closed", obj{"type": "boolean"})},
+				"responses":  obj{"204": response("deleted", nil), "404": errorResponse("not found"), "409": response("ticket is not closed", ref("Conflict"))}},
+		},
+		"/api/tickets/{id}/restore": obj{
+			"parameters": []obj{idParam},
+			"post":       obj{"summary": "Undo a soft delete", "security": adminOnly, "responses": obj{"200": response("restored ticket", ref("Ticket")), "404": errorResponse("not deleted")}},
+		},
+		"/api/tickets/{id}/assign": obj{
+			"parameters": []obj{idParam},
+			"post": obj{"summary": "Assign an agent, null unassigns", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "properties": obj{"agent": obj{"type": "string", "nullable": true}}})},
+				"responses":   obj{"200": response("updated ticket", ref("Ticket")), "422": response("unknown agent", ref("ValidationError"))}},
+		},
+		"/api/agents/{from}/reassign": obj{
+			"parameters": []obj{{"name": "from", "in": "path", "required": true, "description": "agent whose tickets are moved", "schema": obj{"type": "string"}}},
+			"post": obj{"summary": "Move every ticket of an agent that is not closed to another agent, in one transaction", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "required": []string{"to"}, "properties": obj{"to": obj{"type": "string"}}})},
+				"responses": obj{
+					"200": response("tickets moved", obj{"type": "object", "properties": obj{
+						"reassigned": obj{"type": "integer"}, "ids": obj{"type": "array", "items": obj{"type": "integer"}}}}),
+					"400": errorResponse("to is the same agent as from"),
+					"404": errorResponse("unknown agent to move from, with -check-agents"),
+					"422": response("missing to, or unknown agent with -check-agents", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/{id}/reopen": obj{
+			"parameters": []obj{idParam},
+			"post": obj{"summary": "Reopen a resolved or closed ticket", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "properties": obj{"reason": obj{"type": "string"}}})},
+				"responses": obj{
+					"200": response("reopened ticket", ref("Ticket")),
+					"409": response("ticket is not resolved or closed", ref("Conflict")),
+					"422": response("missing reason", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/{id}/resolve": obj{
+			"parameters": []obj{idParam},
+			"post": obj{"summary": "Resolve an open or in progress ticket, recording how; resolving a resolved ticket again corrects the resolution", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "required": []string{"resolution"}, "properties": obj{"resolution": obj{"type": "string", "maxLength": maxCommentLen}}})},
+				"responses": obj{
+					"200": response("resolved ticket", ref("Ticket")),
+					"404": errorResponse("ticket not found"),
+					"409": response("ticket is closed", ref("Conflict")),
+					"422": response("missing or too long resolution", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/{id}/merge": obj{
+			"parameters": []obj{idParam},
+			"post": obj{"summary": "Merge a duplicate into another ticket, moving its comments and attachments and closing it", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "required": []string{"into"}, "properties": obj{"into": obj{"type": "integer", "minimum": 1}}})},
+				"responses": obj{
+					"200": response("ticket merged into", ref("Ticket")),
+					"400": errorResponse("merging a ticket into itself"),
+					"404": errorResponse("ticket or ticket to merge into not found"),
+					"409": response("ticket already merged, or its status may not change to closed", ref("Conflict")),
+					"422": response("missing into", ref("ValidationError")),
+				}},
+		},
+		"/api/tickets/{id}/history": obj{
+			"parameters": []obj{idParam},
+			"get":        obj{"summary": "Audit trail, oldest first", "security": adminOnly, "responses": obj{"200": response("entries", obj{"type": "array", "items": ref("AuditEntry")})}},
+		},
+		"/api/tickets/{id}/acks": obj{
+			"parameters": []obj{idParam},
+			"get": obj{"summary": "Connections that acknowledged the ticket's ticket_critical alerts, oldest first", "security": adminOnly,
+				"responses": obj{"200": response("acknowledgements", obj{"type": "array", "items": ref("TicketAck")}), "404": errorResponse("not found")}},
+		},
+		"/api/tickets/{id}/full": obj{
+			"parameters": []obj{idParam},
+			"get": obj{"summary": "Ticket with its comments, attachments and history", "security": adminOnly,
+				"responses": obj{"200": response("ticket detail", ref("TicketDetail")), "404": errorResponse("not found")}},
+		},
+		"/api/rooms": obj{
+			"get": obj{"summary": "Distinct rooms of current tickets, sorted, for autocomplete",
+				"parameters": []obj{queryParam("q", "only rooms starting with q, ignoring case", obj{"type": "string"})},
+				"responses":  obj{"200": response("rooms", obj{"type": "array", "items": obj{"type": "string"}, "maxItems": maxPerPage})}},
+		},
+		"/api/ws/connections": obj{
+			"get": obj{"summary": "Connected websocket and SSE dashboards, by transport and subscribed room", "security": adminOnly,
+				"responses": obj{"200": response("connection counts", obj{"type": "object", "properties": obj{
+					"count":        obj{"type": "integer"},
+					"by_transport": obj{"type": "object", "additionalProperties": obj{"type": "integer"}},
+					"by_room":      obj{"type": "object", "additionalProperties": obj{"type": "integer"}},
+					"connections":  obj{"type": "array", "items": ref("ConnectionInfo")},
+				}})}},
+		},
+		"/api/ws/disconnect": obj{
+			"post": obj{"summary": "Close one websocket or SSE connection, by its id from /api/ws/connections", "security": adminOnly,
+				"requestBody": obj{"required": true, "content": jsonContent(obj{"type": "object", "required": []string{"id"}, "properties": obj{
+					"id": obj{"type": "string"},
+				}})},
+				"responses": obj{
+					"204": obj{"description": "disconnected"},
+					"404": errorResponse("no such connection"),
+					"422": response("id missing", ref("ValidationError")),
+				}},
+		},
+		"/api/meta": obj{
+			"get": obj{"summary": "Statuses, priorities and the allowed status transitions, as the server validates them",
+				"responses": obj{"200": response("ticket enums and status rules", ref("TicketMeta"))}},
+		},
+		"/api/version": obj{
+			"get": obj{"summary": "Server build version, as in the websocket hello message",
+				"responses": obj{"200": response("version", obj{"type": "object", "properties": obj{"version": obj{"type": "string"}}})}},
+		},
+		"/api/tickets/{id}/events": obj{
+			"parameters": []obj{idParam},
+			"get": obj{"summary": "Server-Sent Events about one ticket", "security": adminOnly,
+				"responses": obj{"200": eventStream, "400": errorResponse("invalid id"), "401": errorResponse("unauthorized")}},
+		},
+		"/api/tickets/{id}/comments": obj{
+			"parameters": []obj{idParam},
+			"get":        obj{"summary": "List comments", "responses": obj{"200": response("comments", obj{"type": "array", "items": ref("Comment")}), "404": errorResponse("not found")}},
+			"post": obj{"summary": "Add a comment", "security": adminOnly, "requestBody": obj{"required": true, "content": jsonContent(ref("Comment"))},
+				"responses": obj{"201": response("created comment", ref("Comment")), "422": response("validation failed", ref("ValidationError"))}},
+		},
+		"/api/tickets/{id}/attachments": obj{
+			"parameters": []obj{idParam},
+			"get":        obj{"summary": "List attachments", "responses": obj{"200": response("attachments", obj{"type": "array", "items": ref("Attachment")})}},
+			"post": obj{"summary": "Upload an attachment. With -public-ids-only it answers 404 without a token",
+				"requestBody": uploadBody,
+				"responses": obj{
+					"201": response("stored attachment", ref("Attachment")),
+					"404": errorResponse("ticket not found"),
+					"413": errorResponse("file too large"),
+					"415": errorResponse("file type not allowed"),
+				}},
+		},
+		"/api/attachments/{id}": obj{
+			"parameters": []obj{idParam},
+			"get": obj{"summary": "Download an attachment", "responses": obj{
+				"200": obj{"description": "file contents", "content": obj{"application/octet-stream": obj{"schema": obj{"type": "string", "format": "binary"}}}},
+				"404": errorResponse("not found"),
+			}},
+		},
+		"/api/public/tickets/{public_id}": obj{
+			"parameters": []obj{{"name": "public_id", "in": "path", "required": true, "schema": obj{"type": "string"}}},
+			"get": obj{"summary": "Get a ticket by the public_id returned when it was created",
+				"parameters": []obj{{"name": "If-None-Match", "in": "header", "schema": obj{"type": "string"}}},
+				"responses": obj{
+					"200": withHeader(response("the ticket", ref("Ticket")), "ETag", "hash of the response body"),
+					"304": obj{"description": "not modified"},
+					"404": errorResponse("not found"),
+				}},
+		},
+		"/api/public/tickets/{public_id}/attachments": obj{
+			"parameters": []obj{{"name": "public_id", "in": "path", "required": true, "schema": obj{"type": "string"}}},
+			"post": obj{"summary": "Upload an attachment to the ticket with this public_id; the answer leaves out ticket_id",
+				"requestBody": uploadBody,
+				"responses": obj{
+					"201": response("stored attachment", ref("Attachment")),
+					"404": errorResponse("ticket not found"),
+					"413": errorResponse("file too large"),
+					"415": errorResponse("file type not allowed"),
+				}},
+		},
+	}
+	// every authenticated operation can answer 403: reads need the viewer role, changes the admin role
+	for _, item := range paths {
+		for method, op := range item.(obj) {
+			o, ok := op.(obj)
+			if !ok || o["security"] == nil {
+				continue
+			}
+			role := "admin"
+			if method == "get" {
+				role = "viewer"
+			}
+			o["responses"].(obj)["403"] = errorResponse("token lacks the " + role + " role")
+		}
+	}
+	return obj{
+		"openapi": "3.0.3",
+		"info":    obj{"title": "PUSTIK Helpdesk Ticketing API", "version": "1.0.0"},
+		"paths":   paths,
+		"components": obj{
+			"schemas":         schemas,
+			"securitySchemes": obj{"bearerAuth": obj{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}},
+		},
+	}
+}
+
+// openAPIDoc is rendered once; the spec only changes with the code
+var openAPIDoc, _ = json.MarshalIndent(buildOpenAPI(), "", "  ")
+
+// openAPIHandler serves GET /api/openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
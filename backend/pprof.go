@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof adds the net/http/pprof handlers under /debug/pprof/ (-enable-pprof), each
+// behind guard: the admin IP allowlist on the -metrics-addr server, an admin token as well on
+// the public one. Profiles and traces run for ?seconds=, which must stay below -write-timeout.
+func registerPprof(mux *http.ServeMux, guard func(http.HandlerFunc) http.HandlerFunc) {
+	// Index also serves the named profiles, e.g. /debug/pprof/heap and /debug/pprof/goroutine
+	mux.HandleFunc("GET /debug/pprof/", guard(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", guard(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", guard(pprof.Profile))
+	// go tool pprof looks symbols up with a POST
+	mux.HandleFunc("GET /debug/pprof/symbol", guard(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", guard(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", guard(pprof.Trace))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+)
+
+// publicIDsOnly makes GET /api/tickets/{id}, and every other public route showing integer ids,
+// answer 404 without a token (-public-ids-only), so the ids can't be walked; the reporter looks
+// the ticket up by its public_id instead
+var publicIDsOnly bool
+
+// hideIntegerIDs wraps a public route that lists or looks up tickets, comments or attachments by
+// their integer ids: with -public-ids-only it answers 404 without a token, like GET /api/tickets/{id}
+func hideIntegerIDs(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if publicIDsOnly && !isAdmin(r) {
+			writeError(w, http.StatusNotFound, "not_found", "not found")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// newPublicID returns 128 random bits in hex, the same format migration 0006 backfilled
+func newPublicID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// publicView is what a caller without a token sees of t: the anonymous reporter is hidden, and
+// so is public_id, which only the reporter gets back from POST /api/tickets
+func publicView(t *Ticket) {
+	anonymize(t)
+	t.PublicID = ""
+}
+
+// loadPublicTicket is loadTicket by public_id
+func loadPublicTicket(ctx context.Context, publicID string) (Ticket, error) {
+	ts, err := queryTickets(ctx, db, "SELECT "+ticketColumns+" FROM tickets WHERE public_id = ? AND deleted_at IS NULL", publicID)
+	if err != nil {
+		return Ticket{}, err
+	}
+	if len(ts) == 0 {
+		return Ticket{}, sql.ErrNoRows
+	}
+	return ts[0], nil
+}
+
+// publicTicketHandler serves GET /api/public/tickets/{public_id}
+func publicTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	t, err := loadPublicTicket(ctx, r.PathValue("public_id"))
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if !isAdmin(r) {
+		anonymize(&t)
+	}
+	writeJSONWithETag(w, r, t)
+}
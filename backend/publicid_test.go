@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHideIntegerIDs(t *testing.T) {
+	prevFlag, prevSecret := publicIDsOnly, jwtSecret
+	t.Cleanup(func() { publicIDsOnly, jwtSecret = prevFlag, prevSecret })
+	jwtSecret = []byte("test-secret")
+	h := hideIntegerIDs(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	token := testToken(t, "viewer")
+	for _, tc := range []struct {
+		flag  bool
+		token string
+		want  int
+	}{
+		{false, "", http.StatusOK},
+		{true, "", http.StatusNotFound},
+		{true, "not-a-token", http.StatusNotFound},
+		{true, token, http.StatusOK},
+	} {
+		publicIDsOnly = tc.flag
+		r := httptest.NewRequest("GET", "/api/tickets", nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.want {
+			t.Errorf("-public-ids-only=%v, token %.10q: %d, want %d", tc.flag, tc.token, w.Code, tc.want)
+		}
+	}
+}
+
+func TestDuplicatesHiddenWithPublicIDsOnly(t *testing.T) {
+	openTestDB(t)
+	prevFlag, prevSecret := publicIDsOnly, jwtSecret
+	t.Cleanup(func() { publicIDsOnly, jwtSecret = prevFlag, prevSecret })
+	jwtSecret = []byte("test-secret")
+	createTestTicket(t, Ticket{Name: "Sari", Phone: "+628111111111", Description: "AC di ruang A1 bocor terus"})
+	const payload = `{"name":"Budi","phone":"08123456789","room":"A1","description":"AC di ruang A1 bocor terus"}`
+	check := func(token string) []map[string]interface{} {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/api/tickets?check_duplicates=true", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		createTicketHandler(w, r)
+		var res struct {
+			PossibleDuplicates []map[string]interface{} `json:"possible_duplicates"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || w.Code != http.StatusOK || len(res.PossibleDuplicates) != 1 {
+			t.Fatalf("check_duplicates: %d %s", w.Code, w.Body)
+		}
+		return res.PossibleDuplicates
+	}
+
+	publicIDsOnly = true
+	d := check("")[0]
+	keys := slices.Sorted(maps.Keys(d))
+	if want := []string{"created_at", "room", "similarity", "status"}; !slices.Equal(keys, want) {
+		t.Errorf("-public-ids-only without a token: suggestion has %v, want only %v", keys, want)
+	}
+	if d["room"] != "A1" || d["status"] != "open" {
+		t.Errorf("-public-ids-only without a token: %v", d)
+	}
+	if d := check(testToken(t, "viewer"))[0]; d["id"] == nil || d["name"] != "Sari" {
+		t.Errorf("-public-ids-only with a token: %v", d)
+	}
+
+	publicIDsOnly = false
+	if d := check("")[0]; d["id"] == nil || d["public_id"] != nil {
+		t.Errorf("without -public-ids-only: %v", d)
+	}
+	if n := countTickets(t); n != 1 {
+		t.Errorf("%d tickets after checking for duplicates, want 1", n)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipLimiter keeps one token bucket per client IP
+type ipLimiter struct {
+	mu       sync.Mutex
+	visitors map[string]*visitor
+	limit    rate.Limit
+	burst    int
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPLimiter allows perMinute requests per IP with the given burst, and drops buckets idle longer than idle
+func newIPLimiter(perMinute float64, burst int, idle time.Duration) *ipLimiter {
+	l := &ipLimiter{
+		visitors: make(map[string]*visitor),
+		limit:    rate.Limit(perMinute / 60),
+		burst:    burst,
+	}
+	go l.cleanup(idle)
+	return l
+}
+
+func (l *ipLimiter) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.visitors[ip]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = time.Now()
+	return v.limiter
+}
+
+func (l *ipLimiter) cleanup(idle time.Duration) {
+	for range time.Tick(idle) {
+		l.mu.Lock()
+		for ip, v := range l.visitors {
+			if time.Since(v.lastSeen) > idle {
+				delete(l.visitors, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// middleware answers 429 with Retry-After once the caller's bucket is empty
+func (l *ipLimiter) middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := l.get(clientIP(r)).Reserve()
+		if delay := res.Delay(); delay > 0 {
+			res.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP is the remote address of the request without the port, or behind a -trusted-proxy
+// the client it forwarded for
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if len(trustedProxies) == 0 {
+		return host
+	}
+	if peer, err := netip.ParseAddr(host); err == nil {
+		if client, ok := forwardedFor(r, peer); ok {
+			return client.String()
+		}
+	}
+	return host
+}
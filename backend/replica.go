@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval is how often the read replica is pinged
+const replicaCheckInterval = 10 * time.Second
+
+// replica is the read replica of -dsn-replica, nil without one. It takes the heavy read-only
+// queries: the ticket list, search, the map, single tickets, stats and the feed. Everything
+// else, writes and the reads inside them above all, stays on db.
+var replica *timedDB
+
+// replicaUp is whether the replica answered its last ping
+var replicaUp atomic.Bool
+
+// readDB is where read-only queries go: the replica while it answers, the primary otherwise
+func readDB() *timedDB {
+	if replica != nil && replicaUp.Load() {
+		return replica
+	}
+	return db
+}
+
+// checkReplica pings the replica and logs when it goes down or comes back
+func checkReplica() {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	err := replica.PingContext(ctx)
+	up := err == nil
+	if replicaUp.Swap(up) == up {
+		return
+	}
+	if up {
+		log.Printf("db replica: up, read queries go to it")
+	} else {
+		log.Printf("db replica: %v, read queries go to the primary until it answers", err)
+	}
+}
+
+// watchReplica runs checkReplica every replicaCheckInterval
+func watchReplica() {
+	for range time.Tick(replicaCheckInterval) {
+		checkReplica()
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// retentionBatch is how many tickets one purge transaction handles, so locks stay short
+const retentionBatch = 100
+
+// retentionModes are the values of -retention-mode: delete removes expired tickets for good,
+// anonymize keeps them for the statistics without the reporter's name and phone
+var retentionModes = []string{"delete", "anonymize"}
+
+// expiredCond matches tickets closed, or soft-deleted, longer than the retention period ago;
+// it takes that period in (negative) seconds twice. A closed ticket's last update is its closing.
+func expiredCond() string {
+	cutoff := sqlDialect.AddSeconds(sqlDialect.Now())
+	return "((status = 'closed' AND updated_at < " + cutoff + ") OR deleted_at < " + cutoff + ")"
+}
+
+// watchRetention purges the tickets expired for longer than after every interval
+func watchRetention(interval, after time.Duration, mode string) {
+	for range time.Tick(interval) {
+		n, err := purgeExpired(after, mode)
+		if err != nil {
+			log.Printf("retention: %v", err)
+		}
+		if n > 0 {
+			log.Printf("retention: %d expired tickets purged (%s)", n, mode)
+		}
+	}
+}
+
+// purgeExpired runs purgeBatch until no expired ticket is left and returns how many it processed
+func purgeExpired(after time.Duration, mode string) (int, error) {
+	total := 0
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		n, err := purgeBatch(ctx, after, mode)
+		cancel()
+		total += n
+		if err != nil || n < retentionBatch {
+			return total, err
+		}
+	}
+}
+
+// purgeBatch deletes or anonymizes up to retentionBatch expired tickets in one transaction.
+// Both modes remove the attachments, which cannot be anonymized, and the stored broadcasts about
+// the tickets. delete also removes comments, tags, history and acknowledgements; anonymize rewrites the name and
+// phone in the history too, leaving the comments, which are written by staff.
+func purgeBatch(ctx context.Context, after time.Duration, mode string) (int, error) {
+	var before, done []Ticket
+	var files []string
+	err := inTx(ctx, func(tx *timedTx) error {
+		before, done, files = nil, nil, nil // a retried transaction starts over
+		secs := -int(after.Seconds())
+		cond := expiredCond()
+		if mode == "anonymize" {
+			// anonymous tickets never stored a name or phone, only their attachments are left to go
+			cond += " AND (anonymous = 0 OR id IN (SELECT ticket_id FROM attachments))"
+		}
+		expired, err := queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE "+cond+" ORDER BY id LIMIT ?", secs, secs, retentionBatch)
+		if err != nil {
+			return err
+		}
+		for _, t := range expired {
+			paths, err := dropAttachments(ctx, tx, t.ID)
+			if err != nil {
+				return err
+			}
+			files = append(files, paths...)
+			if _, err := tx.ExecContext(ctx, "DELETE FROM broadcast_events WHERE ticket_id = ?", t.ID); err != nil {
+				return err
+			}
+			if mode == "delete" {
+				for _, q := range []string{
+					"DELETE FROM comments WHERE ticket_id = ?",
+					"DELETE FROM ticket_tags WHERE ticket_id = ?",
+					"DELETE FROM audit_log WHERE ticket_id = ?",
+					"DELETE FROM ticket_acks WHERE ticket_id = ?",
+					// duplicates merged into it stay, pointing nowhere otherwise
+					"UPDATE tickets SET merged_into = NULL WHERE merged_into = ?",
+					"DELETE FROM tickets WHERE id = ?",
+				} {
+					if _, err := tx.ExecContext(ctx, q, t.ID); err != nil {
+						return err
+					}
+				}
+				before, done = append(before, t), append(done, t)
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE tickets SET name = ?, phone = '', anonymous = 1, version = version + 1 WHERE id = ?", anonymousName, t.ID); err != nil {
+				return err
+			}
+			if err := scrubAudit(ctx, tx, t.ID); err != nil {
+				return err
+			}
+			now, err := queryTickets(ctx, tx, "SELECT "+ticketColumns+" FROM tickets WHERE id = ?", t.ID)
+			if err != nil {
+				return err
+			}
+			// the entry shows the ticket turning anonymous without repeating what was removed
+			scrubbed := t
+			scrubbed.Name, scrubbed.Phone = anonymousName, ""
+			if err := writeAudit(ctx, tx, t.ID, "anonymize", "system", &scrubbed, &now[0]); err != nil {
+				return err
+			}
+			before, done = append(before, scrubbed), append(done, now[0])
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		if err := os.Remove(filepath.Join(uploadsDir, f)); err != nil && !os.IsNotExist(err) {
+			log.Printf("retention: %v", err)
+		}
+	}
+	for i, t := range done {
+		if mode == "delete" {
+			broad.Broadcast("ticket_deleted", t, map[string]TicketID{"id": t.ID})
+		} else {
+			broad.Broadcast("ticket_updated", t, updatedPayload(before[i], t, ""))
+		}
+	}
+	if mode == "delete" {
+		ticketsDeleted.Add(float64(len(done)))
+	} else {
+		ticketsUpdated.Add(float64(len(done)))
+	}
+	return len(done), nil
+}
+
+// dropAttachments deletes the attachment rows of ticket id and returns their files, which the
+// caller removes once the transaction committed
+func dropAttachments(ctx context.Context, tx *timedTx, id TicketID) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT path FROM attachments WHERE ticket_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM attachments WHERE ticket_id = ?", id)
+	return paths, err
+}
+
+// scrubAudit replaces the name and phone in the ticket snapshots of id's history
+func scrubAudit(ctx context.Context, tx *timedTx, id TicketID) error {
+	rows, err := tx.QueryContext(ctx, "SELECT id, old_value, new_value FROM audit_log WHERE ticket_id = ?", id)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id       int
+		old, new sql.NullString
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.old, &e.new); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		old, err := scrubSnapshot(e.old)
+		if err != nil {
+			return err
+		}
+		new, err := scrubSnapshot(e.new)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE audit_log SET old_value = ?, new_value = ? WHERE id = ?", old, new, e.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scrubSnapshot is scrubAudit for one stored ticket JSON; fields it does not know are kept as is
+func scrubSnapshot(v sql.NullString) (sql.NullString, error) {
+	if !v.Valid {
+		return v, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(v.String), &m); err != nil {
+		return v, err
+	}
+	if _, ok := m["name"]; ok {
+		m["name"] = anonymousName
+	}
+	if _, ok := m["phone"]; ok {
+		m["phone"] = ""
+	}
+	b, err := json.Marshal(m)
+	return sql.NullString{String: string(b), Valid: true}, err
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// dbRetries is how many times a DB write is attempted when it keeps failing transiently (-db-retries)
+var dbRetries = 3
+
+const retryBaseDelay = 50 * time.Millisecond
+
+// isTransient reports whether err is worth retrying: refused connections during failover and
+// the dialect's lock errors (deadlocks, lock wait timeouts). A dropped connection mid-statement is not retried: the write
+// may have happened.
+func isTransient(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || sqlDialect.IsTransient(err)
+}
+
+// withRetry runs fn until it succeeds, fails for good or dbRetries attempts are used up,
+// backing off exponentially with jitter in between
+func withRetry(ctx context.Context, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= dbRetries || !isTransient(err) {
+			return err
+		}
+		wait := delay/2 + rand.N(delay)
+		log.Printf("transient db error (attempt %d/%d), retrying in %s: %v", attempt, dbRetries, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// inTx runs fn in a transaction and commits it. A deadlock rolls back the whole transaction,
+// so on transient errors the whole of fn is retried: it must not write the response or broadcast.
+func inTx(ctx context.Context, fn func(tx *timedTx) error) error {
+	return withRetry(ctx, func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// conflictError aborts a transaction whose ticket is not in a state allowing the change;
+// code is the apiError code of the 409
+type conflictError struct {
+	code    string
+	msg     string
+	current Ticket
+}
+
+func (e *conflictError) Error() string { return e.msg }
+
+// txError answers for an error out of inTx: 404 for a missing ticket, 409 for a conflict
+func txError(w http.ResponseWriter, r *http.Request, err error) {
+	var ce *conflictError
+	var fieldErrs FieldErrors
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+	case errors.As(err, &ce):
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"error": apiError{Code: ce.code, Message: ce.msg}, "current": ce.current})
+	case errors.As(err, &fieldErrs):
+		writeFieldErrors(w, fieldErrs)
+	default:
+		dbError(w, err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// roomsHandler serves GET /api/rooms: the distinct rooms of current tickets, sorted, for the room
+// field's autocomplete. ?q= keeps the rooms starting with q, ignoring case. At most maxPerPage
+// rooms are returned. The response stays a plain string array should rooms get a table of their own.
+func roomsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	q := "SELECT DISTINCT room FROM tickets WHERE deleted_at IS NULL"
+	var args []interface{}
+	if prefix := strings.TrimSpace(r.URL.Query().Get("q")); prefix != "" {
+		q += " AND room LIKE ? ESCAPE '!'"
+		args = append(args, likeEscaper.Replace(prefix)+"%")
+	}
+	rows, err := db.QueryContext(ctx, q+" ORDER BY room LIMIT ?", append(args, maxPerPage)...)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	defer rows.Close()
+	rooms := []string{}
+	for rows.Next() {
+		var room string
+		if err := rows.Scan(&room); err != nil {
+			dbError(w, err)
+			return
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		dbError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
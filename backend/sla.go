@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+)
+
+// slaDurations is the response deadline per priority, counted from created_at (-sla-* flags)
+var slaDurations = map[string]time.Duration{
+	"low":      72 * time.Hour,
+	"medium":   24 * time.Hour,
+	"high":     2 * time.Hour,
+	"urgent":   time.Hour,
+	"critical": 30 * time.Minute,
+}
+
+// overdueCond matches open work whose SLA deadline has passed
+func overdueCond() string {
+	return "due_at < " + sqlDialect.Now() + " AND status NOT IN ('resolved', 'closed') AND deleted_at IS NULL"
+}
+
+// overdueTicketsHandler serves GET /api/tickets/overdue with the same filters and shape as the list
+func overdueTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTicketFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	filter.conds = append(filter.conds, overdueCond())
+	writeTicketPage(w, r, filter)
+}
+
+// watchOverdue periodically broadcasts ticket_overdue for tickets that newly passed their deadline.
+// overdue_notified_at makes sure each ticket is announced only once, also across restarts.
+func watchOverdue(interval time.Duration) {
+	for range time.Tick(interval) {
+		ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+		announceOverdue(ctx)
+		cancel()
+	}
+}
+
+func announceOverdue(ctx context.Context) {
+	tickets, err := queryTickets(ctx, db, "SELECT "+ticketColumns+" FROM tickets WHERE "+overdueCond()+" AND overdue_notified_at IS NULL")
+	if err != nil {
+		log.Printf("overdue scan: %v", err)
+		return
+	}
+	for _, t := range tickets {
+		var res sql.Result
+		err := withRetry(ctx, func() error {
+			var err error
+			res, err = db.ExecContext(ctx, "UPDATE tickets SET overdue_notified_at = "+sqlDialect.Now()+" WHERE id = ? AND overdue_notified_at IS NULL", t.ID)
+			return err
+		})
+		if err != nil {
+			log.Printf("overdue mark %d: %v", t.ID, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			broad.Broadcast("ticket_overdue", t, t)
+		}
+	}
+}
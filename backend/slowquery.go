@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+// slowQueryThreshold is how long a statement may take before it is logged (-slow-query-ms); zero disables
+var slowQueryThreshold = 500 * time.Millisecond
+
+// timedDB is the *sql.DB behind db, timing every statement run through it
+type timedDB struct{ *sql.DB }
+
+// timedTx is a transaction of timedDB, timed the same way
+type timedTx struct{ *sql.Tx }
+
+func (d *timedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*timedTx, error) {
+	tx, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{tx}, nil
+}
+
+func (d *timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return d.DB.ExecContext(ctx, query, args...)
+}
+
+func (d *timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return d.DB.QueryContext(ctx, query, args...)
+}
+
+func (d *timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return d.DB.QueryRowContext(ctx, query, args...)
+}
+
+func (t *timedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	return t.Tx.ExecContext(ctx, query, args...)
+}
+
+func (t *timedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	return t.Tx.QueryContext(ctx, query, args...)
+}
+
+func (t *timedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	return t.Tx.QueryRowContext(ctx, query, args...)
+}
+
+// observeQuery logs query as a warning when it ran since start for slowQueryThreshold or longer.
+// Only the SQL is logged: values are bound to placeholders, so the arguments stay out of the log.
+// For a query the time is until the first row is ready, not until the rows are read.
+func observeQuery(query string, start time.Time) {
+	d := time.Since(start)
+	if slowQueryThreshold <= 0 || d < slowQueryThreshold {
+		return
+	}
+	dbSlowQueries.Inc()
+	log.Printf("warning: slow query (%s): %s", d.Round(time.Millisecond), strings.Join(strings.Fields(query), " "))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseStream is an admin's text/event-stream response, for tools that can't speak websocket
+type sseStream struct {
+	addr net.Addr
+}
+
+func (s *sseStream) RemoteAddr() net.Addr { return s.addr }
+
+// Close is a no-op: dropping the stream closes its queue, which ends streamEvents
+func (s *sseStream) Close() error { return nil }
+
+// streamHandler serves GET /api/tickets/stream: every broadcast as a Server-Sent Event,
+// optionally limited with ?priority= and ?room= like the websocket subscription
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	streamEvents(w, r, 0)
+}
+
+// ticketEventsHandler serves GET /api/tickets/{id}/events: the broadcasts about one ticket
+func ticketEventsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", err.Error())
+		return
+	}
+	streamEvents(w, r, id)
+}
+
+// streamEvents holds the response open and writes the queued broadcasts as
+// "id:", "event:" and "data:" lines, flushing after each. A reconnecting EventSource
+// sends Last-Event-ID and gets the events it missed from the replay window.
+func streamEvents(w http.ResponseWriter, r *http.Request, ticketID TicketID) {
+	// EventSource cannot set headers either, so ?token= works here like on /ws/admin
+	if !checkRole(w, wsToken(r), "viewer") {
+		return
+	}
+	q := r.URL.Query()
+	f, err := normalizeWsFilter(wsFilter{Priority: strings.Split(q.Get("priority"), ","), Room: strings.Split(q.Get("room"), ",")})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	f.TicketID = ticketID
+
+	rc := http.NewResponseController(w)
+	s := &sseStream{addr: &net.TCPAddr{}}
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		s.addr = net.TCPAddrFromAddrPort(ap)
+	}
+	send := broad.AddStream(s, f, tokenSubject(wsToken(r)))
+	defer broad.Remove(s)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // keeps nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		since, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || !broad.Resync(s, since) {
+			sendWsReload(s)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		log.Printf("sse %s: %v", s.addr, err)
+		return
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-send:
+			if !ok {
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if err = writeSSE(w, msg); err == nil {
+				err = rc.Flush()
+			}
+		case <-ticker.C:
+			// a comment line keeps proxies from timing out an idle stream
+			rc.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err = io.WriteString(w, ": ping\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			broad.countDrop(s.addr, broad.countFailedWrite(err), err)
+			return
+		}
+	}
+}
+
+// writeSSE writes one queued message as an event named after its "event" with its payload
+// as data. A resync message is unpacked into the events it carries.
+func writeSSE(w io.Writer, msg []byte) error {
+	var m struct {
+		Event   string          `json:"event"`
+		Payload json.RawMessage `json:"payload"`
+		Seq     uint64          `json:"seq"`
+	}
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	if m.Event == "resync" {
+		var r struct {
+			Events []json.RawMessage `json:"events"`
+		}
+		if err := json.Unmarshal(m.Payload, &r); err != nil {
+			return err
+		}
+		for _, e := range r.Events {
+			if err := writeSSE(w, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if m.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", m.Seq); err != nil {
+			return err
+		}
+	}
+	// json.Marshal never emits a newline, so the payload fits on one data line
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Event, m.Payload)
+	return err
+}
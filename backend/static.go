@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staticMaxAge is how long browsers may cache unhashed assets like styles.css (-static-max-age)
+var staticMaxAge = time.Hour
+
+// hashedAsset matches file names carrying a content hash, e.g. app.3f9a8c1b.js; they never change in place
+var hashedAsset = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// staticHandler serves dir with cache headers, and index.html for unknown paths without an extension
+// so deep links reach the client side router. /api/ and /ws/ paths that no route matched stay 404s.
+func staticHandler(dir string) http.Handler {
+	root := http.Dir(dir)
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := path.Clean("/" + r.URL.Path)
+		if p == "/api" || strings.HasPrefix(p, "/api/") || p == "/ws" || strings.HasPrefix(p, "/ws/") {
+			writeError(w, http.StatusNotFound, "not_found", "no such endpoint")
+			return
+		}
+		f, err := root.Open(p)
+		if err == nil {
+			f.Close()
+			setCacheControl(w, p)
+			files.ServeHTTP(w, r)
+			return
+		}
+		if !errors.Is(err, fs.ErrNotExist) || path.Ext(p) != "" {
+			// a missing asset is a real 404, index.html in its place would only confuse the browser
+			files.ServeHTTP(w, r)
+			return
+		}
+		index, err := root.Open("/index.html")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer index.Close()
+		info, err := index.Stat()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "internal_error", err.Error())
+			return
+		}
+		setCacheControl(w, "/index.html")
+		http.ServeContent(w, r, "index.html", info.ModTime(), index)
+	})
+}
+
+// setCacheControl makes HTML revalidate on every load, so a deploy is picked up at once,
+// caches hashed assets for a year and everything else for staticMaxAge
+func setCacheControl(w http.ResponseWriter, p string) {
+	switch ext := path.Ext(p); {
+	case ext == "" || ext == ".html":
+		w.Header().Set("Cache-Control", "no-cache")
+	case hashedAsset.MatchString(path.Base(p)):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(staticMaxAge.Seconds())))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statsTTL is how long GET /api/tickets/stats serves a cached result
+const statsTTL = 10 * time.Second
+
+// TicketStats is the dashboard summary. Resolution time is measured from created_at to
+// the last update of closed tickets and is null while nothing has been closed.
+type TicketStats struct {
+	ByStatus             map[string]int `json:"by_status"`
+	ByPriority           map[string]int `json:"by_priority"`
+	CreatedToday         int            `json:"created_today"`
+	AvgResolutionSeconds *int64         `json:"avg_resolution_seconds"`
+}
+
+var statsCache struct {
+	mu      sync.Mutex
+	stats   TicketStats
+	expires time.Time
+}
+
+// statsHandler serves GET /api/tickets/stats, hitting the DB at most once per statsTTL
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	statsCache.mu.Lock()
+	defer statsCache.mu.Unlock()
+	if time.Now().Before(statsCache.expires) {
+		writeJSON(w, http.StatusOK, statsCache.stats)
+		return
+	}
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	st, err := loadStats(ctx)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	statsCache.stats = st
+	statsCache.expires = time.Now().Add(statsTTL)
+	writeJSON(w, http.StatusOK, st)
+}
+
+// loadStats aggregates the non-deleted tickets in SQL
+func loadStats(ctx context.Context) (TicketStats, error) {
+	st := TicketStats{ByStatus: map[string]int{}, ByPriority: map[string]int{}}
+	for _, s := range allowedStatuses {
+		st.ByStatus[s] = 0
+	}
+	for _, p := range allowedPriorities {
+		st.ByPriority[p] = 0
+	}
+	if err := countBy(ctx, "status", st.ByStatus); err != nil {
+		return st, err
+	}
+	if err := countBy(ctx, "priority", st.ByPriority); err != nil {
+		return st, err
+	}
+	err := readDB().QueryRowContext(ctx, "SELECT COUNT(*) FROM tickets WHERE deleted_at IS NULL AND created_at >= "+sqlDialect.Today()).Scan(&st.CreatedToday)
+	if err != nil {
+		return st, err
+	}
+	var avg sql.NullFloat64
+	err = readDB().QueryRowContext(ctx, "SELECT AVG("+sqlDialect.SecondsBetween("created_at", "updated_at")+") FROM tickets WHERE deleted_at IS NULL AND status = 'closed'").Scan(&avg)
+	if err != nil {
+		return st, err
+	}
+	if avg.Valid {
+		secs := int64(math.Round(avg.Float64))
+		st.AvgResolutionSeconds = &secs
+	}
+	return st, nil
+}
+
+// countBy fills counts with COUNT(*) grouped by column, which must be a trusted column name
+func countBy(ctx context.Context, column string, counts map[string]int) error {
+	rows, err := readDB().QueryContext(ctx, "SELECT "+column+", COUNT(*) FROM tickets WHERE deleted_at IS NULL GROUP BY "+column)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return err
+		}
+		counts[key] = n
+	}
+	return rows.Err()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTags      = 20
+	maxTagLength = 50
+)
+
+// normalizeTags lowercases, trims and dedupes tags, keeping nil (tags omitted) as nil
+func normalizeTags(tags []string) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+	out := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags allowed", maxTags)
+	}
+	return out, nil
+}
+
+// setTicketTags replaces the tags of a ticket, creating missing tags on the way.
+// Run it inside the transaction that writes the ticket.
+func setTicketTags(ctx context.Context, q querier, ticketID TicketID, tags []string) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM ticket_tags WHERE ticket_id = ?", ticketID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		if _, err := q.ExecContext(ctx, sqlDialect.InsertIgnore()+" INTO tags (name) VALUES (?)", tag); err != nil {
+			return err
+		}
+		args[i] = tag
+	}
+	_, err := q.ExecContext(ctx, "INSERT INTO ticket_tags (ticket_id, tag_id) SELECT ?, id FROM tags WHERE name IN ("+placeholders(len(tags))+")",
+		append([]interface{}{ticketID}, args...)...)
+	return err
+}
+
+// attachTags fills Tags on every ticket with a single query
+func attachTags(ctx context.Context, q querier, tickets []Ticket) error {
+	if len(tickets) == 0 {
+		return nil
+	}
+	index := make(map[TicketID]*Ticket, len(tickets))
+	args := make([]interface{}, len(tickets))
+	for i := range tickets {
+		tickets[i].Tags = []string{}
+		index[tickets[i].ID] = &tickets[i]
+		args[i] = tickets[i].ID
+	}
+	rows, err := q.QueryContext(ctx, `SELECT tt.ticket_id, g.name FROM ticket_tags tt JOIN tags g ON g.id = tt.tag_id
+		WHERE tt.ticket_id IN (`+placeholders(len(tickets))+`) ORDER BY g.name`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id TicketID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		if t := index[id]; t != nil {
+			t.Tags = append(t.Tags, name)
+		}
+	}
+	return rows.Err()
+}
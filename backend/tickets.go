@@ -0,0 +1,1141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TicketPage is the paginated response of GET /api/tickets
+type TicketPage struct {
+	Data    []Ticket `json:"data"`
+	Page    int      `json:"page"`
+	PerPage int      `json:"per_page"`
+	Total   int      `json:"total"`
+}
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+)
+
+// parsePagination reads ?page= and ?per_page=, applying defaults and clamping per_page
+func parsePagination(r *http.Request) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", v)
+		}
+	}
+	if v := q.Get("per_page"); v != "" {
+		if perPage, err = strconv.Atoi(v); err != nil || perPage < 1 {
+			return 0, 0, fmt.Errorf("invalid per_page %q", v)
+		}
+		if perPage > maxPerPage {
+			perPage = maxPerPage
+		}
+	}
+	return page, perPage, nil
+}
+
+var (
+	allowedStatuses   = []string{"open", "in_progress", "resolved", "closed"}
+	allowedPriorities = []string{"low", "medium", "high", "urgent", "critical"}
+)
+
+// ticketFilter accumulates the parameterized WHERE conditions of a ticket list query
+type ticketFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+// placeholders returns "?, ?, ..." with n placeholders for an IN clause
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// in adds "column IN (?, ?, ...)" with one placeholder per value
+func (f *ticketFilter) in(column string, values []string) {
+	for _, v := range values {
+		f.args = append(f.args, v)
+	}
+	f.conds = append(f.conds, column+" IN ("+placeholders(len(values))+")")
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally (used with ESCAPE '!')
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// search adds a case-insensitive substring match of term against any of columns
+func (f *ticketFilter) search(term string, columns ...string) {
+	pattern := "%" + likeEscaper.Replace(term) + "%"
+	ors := make([]string, len(columns))
+	for i, c := range columns {
+		ors[i] = c + " LIKE ? ESCAPE '!'"
+		f.args = append(f.args, pattern)
+	}
+	f.conds = append(f.conds, "("+strings.Join(ors, " OR ")+")")
+}
+
+func (f *ticketFilter) where() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// parseEnumParam splits a comma separated query param and checks every value against allowed
+func parseEnumParam(r *http.Request, name string, allowed []string) ([]string, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	var values []string
+	for _, v := range strings.Split(raw, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !slices.Contains(allowed, v) {
+			return nil, fmt.Errorf("invalid %s %q (allowed: %s)", name, v, strings.Join(allowed, ", "))
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseTicketFilter builds the list filter from ?status=, ?priority=, ?room=, ?tag=, ?created_after=,
+// ?created_before=, ?metadata.<key>= and ?include_deleted=.
+// Soft-deleted tickets are only listed for admins that ask for them.
+func parseTicketFilter(r *http.Request) (*ticketFilter, error) {
+	f := &ticketFilter{}
+	includeDeleted := false
+	if v := r.URL.Query().Get("include_deleted"); v != "" {
+		var err error
+		if includeDeleted, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid include_deleted %q", v)
+		}
+	}
+	if !includeDeleted || !isAdmin(r) {
+		f.conds = append(f.conds, "deleted_at IS NULL")
+	}
+	statuses, err := parseEnumParam(r, "status", allowedStatuses)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) > 0 {
+		f.in("status", statuses)
+	}
+	priorities, err := parseEnumParam(r, "priority", allowedPriorities)
+	if err != nil {
+		return nil, err
+	}
+	if len(priorities) > 0 {
+		f.in("priority", priorities)
+	}
+	if raw := r.URL.Query().Get("room"); raw != "" {
+		var rooms []string
+		for _, room := range strings.Split(raw, ",") {
+			if room = strings.TrimSpace(room); room != "" {
+				rooms = append(rooms, room)
+			}
+		}
+		if len(rooms) > 0 {
+			f.in("room", rooms)
+		}
+	}
+	for _, b := range []struct{ param, cond string }{
+		{"created_after", "created_at >= ?"},
+		{"created_before", "created_at <= ?"},
+	} {
+		raw := r.URL.Query().Get(b.param)
+		if raw == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q (expected RFC3339, e.g. 2024-05-01T00:00:00+07:00)", b.param, raw)
+		}
+		f.conds = append(f.conds, b.cond)
+		f.args = append(f.args, sqlDialect.Timestamp(ts))
+	}
+	if raw := r.URL.Query().Get("tag"); raw != "" {
+		tags, err := normalizeTags(strings.Split(raw, ","))
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) > 0 {
+			for _, tag := range tags {
+				f.args = append(f.args, tag)
+			}
+			f.conds = append(f.conds, "id IN (SELECT tt.ticket_id FROM ticket_tags tt JOIN tags g ON g.id = tt.tag_id WHERE g.name IN ("+placeholders(len(tags))+"))")
+		}
+	}
+	if err := f.filterMetadata(r); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// sortExprs maps the ?sort= keys to ORDER BY expressions; priority and status sort by rank, not name
+var sortExprs = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"priority":   rankExpr("priority", allowedPriorities),
+	"status":     rankExpr("status", allowedStatuses),
+}
+
+// rankExpr is a portable CASE giving each value of column its index in values
+func rankExpr(column string, values []string) string {
+	var b strings.Builder
+	b.WriteString("CASE " + column)
+	for i, v := range values {
+		fmt.Fprintf(&b, " WHEN '%s' THEN %d", v, i)
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+// defaultOrderBy is the order of lists without ?sort= and of the websocket init, newest first
+// unless -default-sort says otherwise; both must agree so the admin page can page in older tickets
+var defaultOrderBy = " ORDER BY created_at DESC, id DESC"
+
+// parseSort turns ?sort=key or ?sort=-key (descending) into an ORDER BY clause, defaulting to defaultOrderBy
+func parseSort(r *http.Request) (string, error) {
+	key := r.URL.Query().Get("sort")
+	if key == "" {
+		return defaultOrderBy, nil
+	}
+	return sortClause(key)
+}
+
+// sortClause is the ORDER BY clause of a sort key. id, in the same direction, breaks ties, so
+// tickets created within the same second keep their order and pages don't overlap.
+func sortClause(key string) (string, error) {
+	dir := "ASC"
+	if strings.HasPrefix(key, "-") {
+		key, dir = key[1:], "DESC"
+	}
+	expr, ok := sortExprs[key]
+	if !ok {
+		keys := make([]string, 0, len(sortExprs))
+		for k := range sortExprs {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		return "", fmt.Errorf("invalid sort %q (allowed: %s, prefix - for descending)", key, strings.Join(keys, ", "))
+	}
+	return " ORDER BY " + expr + " " + dir + ", id " + dir, nil
+}
+
+// listLastModified makes GET /api/tickets send Last-Modified and honor If-Modified-Since (-list-last-modified)
+var listLastModified bool
+
+// listTicketsHandler serves GET /api/tickets
+func listTicketsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseTicketFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	if listLastModified && answerNotModified(w, r, filter) {
+		return
+	}
+	writeTicketPage(w, r, filter)
+}
+
+// answerNotModified sets Last-Modified to the newest updated_at among the tickets matching filter
+// and answers 304 when If-Modified-Since is not older. It reports whether it wrote a response.
+// A ticket leaving the result, by being deleted or no longer matching, does not move the date.
+func answerNotModified(w http.ResponseWriter, r *http.Request, filter *ticketFilter) bool {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	var latest sql.NullTime
+	err := readDB().QueryRowContext(ctx, "SELECT updated_at FROM tickets"+filter.where()+" ORDER BY updated_at DESC LIMIT 1", filter.args...).Scan(&latest)
+	if err == sql.ErrNoRows || err == nil && !latest.Valid {
+		return false
+	}
+	if err != nil {
+		dbError(w, err)
+		return true
+	}
+	// HTTP dates are whole seconds in UTC; truncating the same way keeps the comparison exact
+	mod := latest.Time.UTC().Truncate(time.Second)
+	w.Header().Set("Last-Modified", mod.Format(http.TimeFormat))
+	// without it browsers may reuse the list for a while on the strength of Last-Modified alone
+	w.Header().Set("Cache-Control", "no-cache")
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !mod.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// createTicketHandler serves POST /api/tickets. A repeated Idempotency-Key from the same client
+// returns the ticket created the first time instead of inserting a duplicate.
+func createTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	ip := clientIP(r)
+	idemKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(idemKey) > maxIdempotencyKeyLen {
+		writeError(w, http.StatusBadRequest, "invalid_header", "Idempotency-Key too long")
+		return
+	}
+	if idemKey != "" {
+		if prev, ok, err := idempotentTicket(ctx, ip, idemKey); err != nil {
+			dbError(w, err)
+			return
+		} else if ok {
+			writeCreatedTicket(w, prev)
+			return
+		}
+	}
+	var t Ticket
+	if !decodeJSON(w, r, &t) {
+		return
+	}
+	tags, ok := prepareNewTicket(ctx, w, &t)
+	if !ok {
+		return
+	}
+	if answerDuplicates(ctx, w, r, t) {
+		return
+	}
+	// insert and read back in one transaction so the broadcast matches what was committed
+	input, actor := t, actorFromRequest(r)
+	err := inTx(ctx, func(tx *timedTx) error {
+		var err error
+		if t, err = insertTicket(ctx, tx, input, tags, actor); err != nil {
+			return err
+		}
+		if idemKey != "" {
+			return rememberIdempotencyKey(ctx, tx, ip, idemKey, t.ID)
+		}
+		return nil
+	})
+	if err != nil && idemKey != "" && sqlDialect.IsDuplicateKey(err) {
+		// a concurrent retry won the race: our insert was rolled back, answer with its ticket
+		prev, ok, err := idempotentTicket(ctx, ip, idemKey)
+		if err != nil || !ok {
+			dbError(w, fmt.Errorf("idempotency key lookup: %v", err))
+			return
+		}
+		writeCreatedTicket(w, prev)
+		return
+	}
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+
+	writeCreatedTicket(w, t)
+	announceCreated(t)
+}
+
+// insertTicket inserts t with its tags and audit entry inside tx and returns the stored row
+func insertTicket(ctx context.Context, tx querier, t Ticket, tags []string, actor string) (Ticket, error) {
+	publicID, err := newPublicID()
+	if err != nil {
+		return Ticket{}, err
+	}
+	// the current timestamp is fixed per statement, so due_at is exactly created_at plus the SLA
+	q := `INSERT INTO tickets (name, phone, room, description, anonymous, status, priority, assigned_to, lat, lng, public_id, metadata, due_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ` + sqlDialect.AddSeconds(sqlDialect.Now()) + `)`
+	res, err := tx.ExecContext(ctx, q, t.Name, t.Phone, t.Room, t.Description, t.Anonymous, t.Status, t.Priority, t.AssignedTo, t.Lat, t.Lng, publicID, metadataValue(t.Metadata), int(slaDurations[t.Priority].Seconds()))
+	if err != nil {
+		return Ticket{}, err
+	}
+	id, _ := res.LastInsertId()
+	if err := setTicketTags(ctx, tx, TicketID(id), tags); err != nil {
+		return Ticket{}, err
+	}
+	if t, err = loadTicket(ctx, tx, TicketID(id)); err != nil {
+		return Ticket{}, err
+	}
+	return t, writeAudit(ctx, tx, t.ID, "create", actor, nil, &t)
+}
+
+// prepareNewTicket applies the defaults and every check of ticket creation to t and returns its
+// normalized tags. On failure it has written the 422 (or database error) and returns false.
+// createTicketHandler and validateTicketHandler both go through it so they can't disagree.
+func prepareNewTicket(ctx context.Context, w http.ResponseWriter, t *Ticket) ([]string, bool) {
+	tags, errs, err := normalizeNewTicket(ctx, t)
+	if err != nil {
+		dbError(w, err)
+		return nil, false
+	}
+	if errs != nil {
+		writeFieldErrors(w, errs)
+		return nil, false
+	}
+	if !checkOpenPerPhone(ctx, w, t.Phone) {
+		return nil, false
+	}
+	return tags, true
+}
+
+// normalizeNewTicket applies the defaults and field checks of ticket creation to t and returns its
+// normalized tags, or what is wrong with its fields. err is only set when the database failed.
+func normalizeNewTicket(ctx context.Context, t *Ticket) ([]string, FieldErrors, error) {
+	applyTicketDefaults(t)
+	// before validating, so a description that was only markup counts as empty
+	sanitizeText(&t.Name, &t.Room, &t.Description)
+	if err := validateTicket(*t); err != nil {
+		return nil, err.(FieldErrors), nil
+	}
+	t.Phone = normalizePhone(t.Phone)
+	// nothing identifying is stored for anonymous reporters
+	anonymize(t)
+	t.AssignedTo = normalizeAgent(t.AssignedTo)
+	if t.AssignedTo != nil {
+		ok, err := agentExists(ctx, *t.AssignedTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, FieldErrors{"assigned_to": "unknown agent"}, nil
+		}
+	}
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return nil, FieldErrors{"tags": err.Error()}, nil
+	}
+	return tags, nil, nil
+}
+
+// maxOpenPerPhone is how many open or in_progress tickets one phone number may have (-max-open-per-phone, 0 = no limit)
+var maxOpenPerPhone = 5
+
+// checkOpenPerPhone writes a 429 and returns false when phone already has maxOpenPerPhone tickets
+// waiting to be resolved. Anonymous and email tickets have no phone and are never limited. Two
+// concurrent submissions can both pass; this curbs abuse and doesn't need to be exact.
+func checkOpenPerPhone(ctx context.Context, w http.ResponseWriter, phone string) bool {
+	if maxOpenPerPhone <= 0 || phone == "" {
+		return true
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM tickets WHERE phone = ? AND status IN ('open', 'in_progress') AND deleted_at IS NULL", phone).Scan(&n)
+	if err != nil {
+		dbError(w, err)
+		return false
+	}
+	if n >= maxOpenPerPhone {
+		writeError(w, http.StatusTooManyRequests, "too_many_open_tickets", fmt.Sprintf("this phone number already has %d open tickets; please wait until they are resolved before reporting more", n))
+		return false
+	}
+	return true
+}
+
+// validateTicketHandler serves POST /api/tickets/validate: the checks of POST /api/tickets
+// without writing anything, for inline form feedback
+func validateTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	var t Ticket
+	if !decodeJSON(w, r, &t) {
+		return
+	}
+	if _, ok := prepareNewTicket(ctx, w, &t); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"valid": true})
+}
+
+// announceCreated tells admin websockets, metrics and the on-call mail about a committed ticket
+func announceCreated(t Ticket) {
+	ticketsCreated.Inc()
+	broad.Broadcast("ticket_created", t, t)
+	notifyIfImportant(t)
+	escalateIfCritical(nil, t)
+}
+
+// escalateIfCritical broadcasts ticket_critical and emails the on-call team right away when t
+// has just become critical, either created that way or raised from a lower priority
+func escalateIfCritical(before *Ticket, t Ticket) {
+	if t.Priority != "critical" || (before != nil && before.Priority == "critical") {
+		return
+	}
+	broad.Broadcast("ticket_critical", t, t)
+	notifyNow(t)
+}
+
+// searchHandler supports GET /api/tickets/search?q=..., matching q against the free-text columns.
+// It accepts the same filters and pagination as the list endpoint and returns the same shape.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", "missing q")
+		return
+	}
+	filter, err := parseTicketFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	filter.search(q, "name", "phone", "room", "description")
+	writeTicketPage(w, r, filter)
+}
+
+// writeTicketPage runs the paginated list query for filter and encodes a TicketPage
+func writeTicketPage(w http.ResponseWriter, r *http.Request, filter *ticketFilter) {
+	page, perPage, err := parsePagination(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	orderBy, err := parseSort(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_parameter", err.Error())
+		return
+	}
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	rdb := readDB()
+	var total int
+	if err := rdb.QueryRowContext(ctx, "SELECT COUNT(*) FROM tickets"+filter.where(), filter.args...).Scan(&total); err != nil {
+		dbError(w, err)
+		return
+	}
+	args := append(filter.args, perPage, (page-1)*perPage)
+	data, err := queryTickets(ctx, rdb, "SELECT "+ticketColumns+" FROM tickets"+filter.where()+orderBy+" LIMIT ? OFFSET ?", args...)
+	if err != nil {
+		dbError(w, err)
+		return
+	}
+	if !isAdmin(r) {
+		for i := range data {
+			publicView(&data[i])
+		}
+	}
+	res := TicketPage{Data: data, Page: page, PerPage: perPage, Total: total}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// writeCreatedTicket answers a successful POST /api/tickets; idempotent replays get the same response
+func writeCreatedTicket(w http.ResponseWriter, t Ticket) {
+	w.Header().Set("Location", fmt.Sprintf("/api/tickets/%d", t.ID))
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// ticketID parses the {id} path wildcard
+func ticketID(r *http.Request) (TicketID, error) {
+	return parseTicketID(r.PathValue("id"))
+}
+
+// getTicketHandler serves GET /api/tickets/{id}
+func getTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	admin := isAdmin(r)
+	// the same answer as a missing ticket, so probing ids tells nothing
+	if publicIDsOnly && !admin {
+		writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+		return
+	}
+	t, err := loadTicket(ctx, readDB(), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+			return
+		}
+		dbError(w, err)
+		return
+	}
+	if !admin {
+		publicView(&t)
+	}
+	writeJSONWithETag(w, r, t)
+}
+
+// TicketDetail is the response of GET /api/tickets/{id}/full
+type TicketDetail struct {
+	Ticket      Ticket       `json:"ticket"`
+	Comments    []Comment    `json:"comments"`
+	Attachments []Attachment `json:"attachments"`
+	History     []AuditEntry `json:"history"`
+}
+
+// fullTicketHandler serves GET /api/tickets/{id}/full: the ticket with its comments, attachments
+// and history, so the admin detail view needs one round trip instead of four
+func fullTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var d TicketDetail
+	if d.Ticket, err = loadTicket(ctx, db, id); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "not_found", "ticket not found")
+			return
+		}
+		dbError(w, err)
+		return
+	}
+	if d.Comments, err = queryComments(ctx, db, id); err != nil {
+		dbError(w, err)
+		return
+	}
+	if d.Attachments, err = queryAttachments(ctx, db, id); err != nil {
+		dbError(w, err)
+		return
+	}
+	if d.History, err = queryHistory(ctx, db, id); err != nil {
+		dbError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// updateTicketHandler serves PUT /api/tickets/{id}. The body must carry the version it was based on;
+// a stale version gets 409 with the current ticket so the client can merge.
+func updateTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var body struct {
+		Ticket
+		StatusReason *string `json:"status_reason"`
+	}
+	if !decodeJSON(w, r, &body) {
+		return
+	}
+	t := body.Ticket
+	sanitizeText(&t.Name, &t.Room, &t.Description)
+	// email and anonymous tickets have no phone, anything else must normalize
+	if t.Phone != "" {
+		if t.Phone = normalizePhone(t.Phone); t.Phone == "" {
+			writeFieldErrors(w, FieldErrors{"phone": "must be a valid phone number"})
+			return
+		}
+	}
+	t.AssignedTo = normalizeAgent(t.AssignedTo)
+	if !validateAssignee(ctx, w, t.AssignedTo) {
+		return
+	}
+	if errs := validateLocation(t.Lat, t.Lng); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	if errs := validateMetadata(t.Metadata); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		writeFieldErrors(w, FieldErrors{"tags": err.Error()})
+		return
+	}
+	in := t
+	var before Ticket
+	var reason string
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		if before, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		if err := checkTransition(before.Status, in.Status); err != nil {
+			return &conflictError{"invalid_transition", err.Error(), before}
+		}
+		if reason, err = checkStatusReason(before.Status, in.Status, body.StatusReason); err != nil {
+			return err
+		}
+		// validate the ticket as it will be stored, but only report the fields this update changes:
+		// untouched ones may predate today's rules (email tickets have no phone, for one)
+		merged := in
+		merged.Anonymous = before.Anonymous
+		if merged.Lat == nil {
+			merged.Lat = before.Lat
+		}
+		if merged.Lng == nil {
+			merged.Lng = before.Lng
+		}
+		if merged.Metadata == nil {
+			merged.Metadata = before.Metadata
+		}
+		if err := validateTicket(merged); err != nil {
+			changed := map[string]bool{
+				"name": in.Name != before.Name, "phone": in.Phone != before.Phone, "room": in.Room != before.Room,
+				"description": in.Description != before.Description, "status": in.Status != before.Status,
+				"priority": in.Priority != before.Priority, "lat": in.Lat != nil, "lng": in.Lng != nil, "metadata": in.Metadata != nil,
+			}
+			errs := FieldErrors{}
+			for field, msg := range err.(FieldErrors) {
+				if changed[field] {
+					errs[field] = msg
+				}
+			}
+			if len(errs) > 0 {
+				return errs
+			}
+		}
+		// optimistic locking: only apply the update on top of the version the client edited.
+		// Like tags, an omitted location or metadata is left as it is.
+		q := `UPDATE tickets SET ` + resolvedAtSet() + `, name=?, phone=?, room=?, description=?, status=?, priority=?, assigned_to=?,
+			lat=COALESCE(?, lat), lng=COALESCE(?, lng), metadata=COALESCE(?, metadata), version=version+1
+			WHERE id=? AND version=? AND deleted_at IS NULL`
+		res, err := tx.ExecContext(ctx, q, in.Status, in.Status, in.Name, in.Phone, in.Room, in.Description, in.Status, in.Priority, in.AssignedTo, in.Lat, in.Lng, metadataValue(in.Metadata), id, in.Version)
+		if err != nil {
+			return err
+		}
+		if updated, _ := res.RowsAffected(); updated == 0 {
+			return &conflictError{"version_conflict", "version conflict", before}
+		}
+		// omitted tags are left as they are, [] clears them
+		if tags != nil {
+			if err := setTicketTags(ctx, tx, id, tags); err != nil {
+				return err
+			}
+		}
+		// fetch updated row
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAuditReason(ctx, tx, id, "update", actorFromRequest(r), reason, &before, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_updated", t, updatedPayload(before, t, reason))
+	escalateIfCritical(&before, t)
+}
+
+// ticketUpdate is the ticket_updated payload of PUT and PATCH: the ticket, plus the
+// status_reason when the change came with one
+type ticketUpdate struct {
+	Ticket
+	Reason string `json:"reason,omitempty"`
+}
+
+// ticketPatch is the body of PATCH /api/tickets/{id}: only the fields present are changed.
+// Assignment goes through POST /api/tickets/{id}/assign and reopening through /reopen.
+type ticketPatch struct {
+	Name        *string  `json:"name"`
+	Phone       *string  `json:"phone"`
+	Room        *string  `json:"room"`
+	Description *string  `json:"description"`
+	Status      *string  `json:"status"`
+	Priority    *string  `json:"priority"`
+	Tags        []string `json:"tags"` // omitted or null leaves the tags, [] clears them
+	Lat         *float64 `json:"lat"`
+	Lng         *float64 `json:"lng"`
+	// Metadata replaces all custom fields; {} clears them
+	Metadata map[string]interface{} `json:"metadata"`
+	// StatusReason is why the status changes; required for resolved and closed
+	StatusReason *string `json:"status_reason"`
+	// Version is optional; when given the patch only applies on top of that version
+	Version *int `json:"version"`
+}
+
+// applyTo copies the fields present in p onto t
+func (p ticketPatch) applyTo(t *Ticket) {
+	for _, f := range []struct{ dst, src *string }{
+		{&t.Name, p.Name}, {&t.Phone, p.Phone}, {&t.Room, p.Room},
+		{&t.Description, p.Description}, {&t.Status, p.Status}, {&t.Priority, p.Priority},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if p.Lat != nil {
+		t.Lat = p.Lat
+	}
+	if p.Lng != nil {
+		t.Lng = p.Lng
+	}
+	if p.Metadata != nil {
+		t.Metadata = p.Metadata
+	}
+}
+
+// patchTicketHandler serves PATCH /api/tickets/{id}, updating only the fields in the body
+func patchTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var p ticketPatch
+	if !decodeJSON(w, r, &p) {
+		return
+	}
+	sanitizeText(p.Name, p.Room, p.Description)
+	tags, err := normalizeTags(p.Tags)
+	if err != nil {
+		writeFieldErrors(w, FieldErrors{"tags": err.Error()})
+		return
+	}
+	if p.Phone != nil {
+		if phone := normalizePhone(*p.Phone); phone != "" {
+			p.Phone = &phone
+		}
+	}
+	// the columns present in the body; each column is named like its JSON field
+	type change struct {
+		column string
+		value  interface{}
+	}
+	var changes []change
+	for _, c := range []struct {
+		column string
+		value  *string
+	}{
+		{"name", p.Name}, {"phone", p.Phone}, {"room", p.Room},
+		{"description", p.Description}, {"status", p.Status}, {"priority", p.Priority},
+	} {
+		if c.value != nil {
+			changes = append(changes, change{c.column, *c.value})
+		}
+	}
+	for _, c := range []struct {
+		column string
+		value  *float64
+	}{{"lat", p.Lat}, {"lng", p.Lng}} {
+		if c.value != nil {
+			changes = append(changes, change{c.column, *c.value})
+		}
+	}
+	if p.Metadata != nil {
+		changes = append(changes, change{"metadata", metadataValue(p.Metadata)})
+	}
+	if len(changes) == 0 && tags == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "empty patch")
+		return
+	}
+	var t Ticket
+	var before Ticket
+	var reason string
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		if before, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		if p.Version != nil && *p.Version != before.Version {
+			return &conflictError{"version_conflict", "version conflict", before}
+		}
+		if p.Status != nil {
+			if err := checkTransition(before.Status, *p.Status); err != nil {
+				return &conflictError{"invalid_transition", err.Error(), before}
+			}
+			if reason, err = checkStatusReason(before.Status, *p.Status, p.StatusReason); err != nil {
+				return err
+			}
+		}
+		// validate the patched ticket, but only report fields the client sent:
+		// untouched ones may predate today's rules (email tickets have no phone, for one)
+		merged := before
+		p.applyTo(&merged)
+		if err := validateTicket(merged); err != nil {
+			errs := FieldErrors{}
+			for _, c := range changes {
+				if msg, ok := err.(FieldErrors)[c.column]; ok {
+					errs[c.column] = msg
+				}
+			}
+			if len(errs) > 0 {
+				return errs
+			}
+		}
+		sets := []string{"version = version + 1"}
+		args := []interface{}{}
+		if p.Status != nil {
+			sets = append([]string{resolvedAtSet()}, sets...)
+			args = append(args, *p.Status, *p.Status)
+		}
+		for _, c := range changes {
+			sets = append(sets, c.column+" = ?")
+			args = append(args, c.value)
+		}
+		// the version check above ran in this transaction, matching it again guards against a concurrent PUT
+		args = append(args, id, before.Version)
+		res, err := tx.ExecContext(ctx, "UPDATE tickets SET "+strings.Join(sets, ", ")+" WHERE id = ? AND version = ? AND deleted_at IS NULL", args...)
+		if err != nil {
+			return err
+		}
+		if updated, _ := res.RowsAffected(); updated == 0 {
+			return &conflictError{"version_conflict", "version conflict", before}
+		}
+		if tags != nil {
+			if err := setTicketTags(ctx, tx, id, tags); err != nil {
+				return err
+			}
+		}
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAuditReason(ctx, tx, id, "update", actorFromRequest(r), reason, &before, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_updated", t, updatedPayload(before, t, reason))
+	escalateIfCritical(&before, t)
+}
+
+// deleteTicketHandler serves DELETE /api/tickets/{id}
+func deleteTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+	var deleted Ticket
+	err = inTx(ctx, func(tx *timedTx) error {
+		before, err := loadTicket(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		deleted = before
+		// only closed tickets go without ?force=true, so active work is not removed by a stray click
+		if before.Status != "closed" && !force {
+			return &conflictError{"ticket_not_closed", "ticket is " + before.Status + "; close it first or delete with ?force=true", before}
+		}
+		// soft delete: the row is kept for disputes and can be restored
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET deleted_at = "+sqlDialect.Now()+" WHERE id = ?", id); err != nil {
+			return err
+		}
+		return writeAudit(ctx, tx, id, "delete", actorFromRequest(r), &before, nil)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ticketsDeleted.Inc()
+	broad.Broadcast("ticket_deleted", deleted, map[string]TicketID{"id": id})
+}
+
+// assignTicketHandler serves POST /api/tickets/{id}/assign with {"agent":"alice"}; null or "" unassigns
+func assignTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var req struct {
+		Agent *string `json:"agent"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	agent := normalizeAgent(req.Agent)
+	if !validateAssignee(ctx, w, agent) {
+		return
+	}
+	var t Ticket
+	err = inTx(ctx, func(tx *timedTx) error {
+		before, err := loadTicket(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET assigned_to = ?, version = version + 1 WHERE id = ?", agent, id); err != nil {
+			return err
+		}
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAudit(ctx, tx, id, "assign", actorFromRequest(r), &before, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_assigned", t, t)
+}
+
+// restoreTicketHandler serves POST /api/tickets/{id}/restore, undoing a soft delete
+func restoreTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var t Ticket
+	err = inTx(ctx, func(tx *timedTx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE tickets SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return sql.ErrNoRows
+		}
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAudit(ctx, tx, id, "restore", actorFromRequest(r), nil, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_restored", t, t)
+}
+
+// reopenTicketHandler serves POST /api/tickets/{id}/reopen with {"reason":"..."}. Only resolved or
+// closed tickets can be reopened; the reason is kept as a comment on the ticket.
+func reopenTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	switch {
+	case reason == "":
+		writeFieldErrors(w, FieldErrors{"reason": "is required"})
+		return
+	case utf8.RuneCountInString(reason) > maxCommentLen:
+		writeFieldErrors(w, FieldErrors{"reason": "is too long"})
+		return
+	}
+	actor := actorFromRequest(r)
+	var t Ticket
+	var c Comment
+	err = inTx(ctx, func(tx *timedTx) error {
+		before, err := loadTicket(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(reopenableStatuses, before.Status) {
+			return &conflictError{"invalid_transition", "only resolved or closed tickets can be reopened", before}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET status = 'open', resolution = NULL, resolved_at = NULL, version = version + 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+		c = Comment{TicketID: id, Author: actor, Body: reason}
+		res, err := tx.ExecContext(ctx, "INSERT INTO comments (ticket_id, author, body) VALUES (?, ?, ?)", c.TicketID, c.Author, c.Body)
+		if err != nil {
+			return err
+		}
+		cid, _ := res.LastInsertId()
+		c.ID = int(cid)
+		if err := tx.QueryRowContext(ctx, "SELECT created_at FROM comments WHERE id = ?", cid).Scan(&c.CreatedAt); err != nil {
+			return err
+		}
+		inDisplayZone(&c.CreatedAt)
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAuditReason(ctx, tx, id, "reopen", actor, reason, &before, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_reopened", t, map[string]interface{}{"ticket": t, "reason": reason})
+	broad.Broadcast("comment_added", t, map[string]interface{}{"ticket_id": id, "comment": c})
+}
+
+// resolvedAtSet is the SET clause keeping resolved_at in step with a status change, taking the
+// new status twice: moving into resolved records the time, resolved and closed tickets keep theirs,
+// and any other status clears it. It compares with the status before the change, so it goes
+// before "status = ?" (MySQL assigns from left to right).
+func resolvedAtSet() string {
+	return "resolved_at = CASE WHEN ? = 'resolved' AND status <> 'resolved' THEN " + sqlDialect.Now() +
+		" WHEN ? IN ('resolved', 'closed') THEN resolved_at ELSE NULL END"
+}
+
+// resolveTicketHandler serves POST /api/tickets/{id}/resolve with {"resolution": "replaced the router"}:
+// an open or in progress ticket becomes resolved, with the resolution and resolved_at recorded, and
+// a resolved one gets its resolution corrected. The resolution is also the reason in the history.
+func resolveTicketHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := dbContext(r)
+	defer cancel()
+	id, err := ticketID(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_id", "invalid id")
+		return
+	}
+	var req struct {
+		Resolution string `json:"resolution"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	sanitizeText(&req.Resolution)
+	resolution := strings.TrimSpace(req.Resolution)
+	switch {
+	case resolution == "":
+		writeFieldErrors(w, FieldErrors{"resolution": "is required"})
+		return
+	case utf8.RuneCountInString(resolution) > maxCommentLen:
+		writeFieldErrors(w, FieldErrors{"resolution": "is too long"})
+		return
+	}
+	actor := actorFromRequest(r)
+	var before, t Ticket
+	err = inTx(ctx, func(tx *timedTx) error {
+		var err error
+		if before, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		// resolving a resolved ticket again corrects its resolution and keeps resolved_at
+		if err := checkTransition(before.Status, "resolved"); err != nil {
+			return &conflictError{"invalid_transition", err.Error(), before}
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tickets SET resolution = ?, "+resolvedAtSet()+", status = 'resolved', version = version + 1 WHERE id = ?",
+			resolution, "resolved", "resolved", id); err != nil {
+			return err
+		}
+		if t, err = loadTicket(ctx, tx, id); err != nil {
+			return err
+		}
+		return writeAuditReason(ctx, tx, id, "resolve", actor, resolution, &before, &t)
+	})
+	if err != nil {
+		txError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+	ticketsUpdated.Inc()
+	broad.Broadcast("ticket_updated", t, updatedPayload(before, t, resolution))
+}
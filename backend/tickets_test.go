@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUpdateTicketValidates(t *testing.T) {
+	openTestDB(t)
+	tk := createTestTicket(t, Ticket{Description: "AC bocor"})
+	put := func(field string, value interface{}) (int, map[string]interface{}) {
+		body := map[string]interface{}{"name": tk.Name, "phone": tk.Phone, "room": tk.Room, "description": tk.Description,
+			"status": tk.Status, "priority": tk.Priority, "version": tk.Version}
+		body[field] = value
+		b, _ := json.Marshal(body)
+		w := serve(updateTicketHandler, "PUT", "/api/tickets/x", string(b), "id", fmt.Sprint(tk.ID))
+		if w.Code != http.StatusUnprocessableEntity {
+			return w.Code, nil
+		}
+		code, fields := errorFields(t, w)
+		if code != "validation_failed" {
+			t.Errorf("PUT %s=%q: code %q", field, value, code)
+		}
+		return w.Code, fields
+	}
+	for _, tc := range []struct {
+		field string
+		value string
+	}{
+		{"priority", "bogus"},
+		{"priority", ""},
+		{"name", " "},
+		{"room", ""},
+		{"description", string(make([]byte, maxDescriptionLen+1))},
+	} {
+		status, fields := put(tc.field, tc.value)
+		if status != http.StatusUnprocessableEntity || fields[tc.field] == nil {
+			t.Errorf("PUT %s=%.10q: %d %v, want 422 naming %s", tc.field, tc.value, status, fields, tc.field)
+		}
+	}
+	if status, _ := put("priority", "high"); status != http.StatusOK {
+		t.Errorf("valid PUT: %d, want 200", status)
+	}
+}
+
+func TestResolvedAtFollowsStatus(t *testing.T) {
+	openTestDB(t)
+	resolvedAt := func(id TicketID) *time.Time {
+		t.Helper()
+		tk, err := loadTicket(context.Background(), db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tk.ResolvedAt
+	}
+	patch := func(id TicketID, body string) {
+		t.Helper()
+		if w := serve(patchTicketHandler, "PATCH", "/api/tickets/x", body, "id", fmt.Sprint(id)); w.Code != http.StatusOK {
+			t.Fatalf("PATCH %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	a := createTestTicket(t, Ticket{Status: "in_progress"})
+	patch(a.ID, `{"priority":"high"}`)
+	if resolvedAt(a.ID) != nil {
+		t.Fatal("resolved_at set without a status change")
+	}
+	patch(a.ID, `{"status":"resolved","status_reason":"router diganti"}`)
+	stamped := resolvedAt(a.ID)
+	if stamped == nil {
+		t.Fatal("PATCH to resolved left resolved_at empty")
+	}
+	patch(a.ID, `{"status":"closed","status_reason":"selesai"}`)
+	if got := resolvedAt(a.ID); got == nil || !got.Equal(*stamped) {
+		t.Fatalf("closing changed resolved_at from %v to %v", stamped, got)
+	}
+	if w := serve(reopenTicketHandler, "POST", "/api/tickets/x/reopen", `{"reason":"bocor lagi"}`, "id", fmt.Sprint(a.ID)); w.Code != http.StatusOK {
+		t.Fatalf("reopen: %d %s", w.Code, w.Body)
+	}
+	if resolvedAt(a.ID) != nil {
+		t.Fatal("reopening kept resolved_at")
+	}
+
+	b := createTestTicket(t, Ticket{Status: "in_progress"})
+	body := fmt.Sprintf(`{"name":%q,"phone":%q,"room":%q,"status":"resolved","priority":%q,"version":%d,"status_reason":"ok"}`, b.Name, b.Phone, b.Room, b.Priority, b.Version)
+	if w := serve(updateTicketHandler, "PUT", "/api/tickets/x", body, "id", fmt.Sprint(b.ID)); w.Code != http.StatusOK {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	if resolvedAt(b.ID) == nil {
+		t.Fatal("PUT to resolved left resolved_at empty")
+	}
+
+	c := createTestTicket(t, Ticket{Status: "in_progress"})
+	body = fmt.Sprintf(`{"ids":[%d],"status":"resolved","status_reason":"ok"}`, c.ID)
+	if w := serve(bulkStatusHandler, "PATCH", "/api/tickets/bulk", body); w.Code != http.StatusOK {
+		t.Fatalf("bulk: %d %s", w.Code, w.Body)
+	}
+	if resolvedAt(c.ID) == nil {
+		t.Fatal("bulk update to resolved left resolved_at empty")
+	}
+}
+
+func TestResolveTicket(t *testing.T) {
+	openTestDB(t)
+	resolve := func(id TicketID, resolution string) *httptest.ResponseRecorder {
+		return serve(resolveTicketHandler, "POST", "/api/tickets/x/resolve", fmt.Sprintf(`{"resolution":%q}`, resolution), "id", fmt.Sprint(id))
+	}
+
+	// the shortcut is in the transition table /api/meta advertises, so resolve follows it
+	var meta ticketMeta
+	if err := json.Unmarshal(serve(metaHandler, "GET", "/api/meta", "").Body.Bytes(), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(meta.Transitions["open"], "resolved") {
+		t.Fatalf("/api/meta transitions from open: %v", meta.Transitions["open"])
+	}
+	open := createTestTicket(t, Ticket{Description: "lampu mati"})
+	w := resolve(open.ID, "sekring diganti")
+	if w.Code != http.StatusOK {
+		t.Fatalf("resolving an open ticket: %d %s", w.Code, w.Body)
+	}
+	first, err := loadTicket(context.Background(), db, open.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != "resolved" || first.Resolution == nil || *first.Resolution != "sekring diganti" || first.ResolvedAt == nil {
+		t.Fatalf("after resolve: status %s, resolution %v, resolved_at %v", first.Status, first.Resolution, first.ResolvedAt)
+	}
+
+	// a second resolve corrects the resolution without moving resolved_at
+	if _, err := db.ExecContext(context.Background(), "UPDATE tickets SET resolved_at = '2026-10-01 08:00:00' WHERE id = ?", open.ID); err != nil {
+		t.Fatal(err)
+	}
+	if w := resolve(open.ID, "sekring dan stopkontak diganti"); w.Code != http.StatusOK {
+		t.Fatalf("resolving again: %d %s", w.Code, w.Body)
+	}
+	corrected, err := loadTicket(context.Background(), db, open.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *corrected.Resolution != "sekring dan stopkontak diganti" || !corrected.ResolvedAt.Equal(time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("after correcting: resolution %q, resolved_at %v", *corrected.Resolution, corrected.ResolvedAt)
+	}
+
+	closed := createTestTicket(t, Ticket{Status: "closed"})
+	w = resolve(closed.ID, "sudah beres")
+	if code, _ := errorFields(t, w); w.Code != http.StatusConflict || code != "invalid_transition" {
+		t.Errorf("resolving a closed ticket: %d %s", w.Code, w.Body)
+	}
+	if after, err := loadTicket(context.Background(), db, closed.ID); err != nil || after.Status != "closed" || after.Resolution != nil || after.Version != closed.Version {
+		t.Errorf("refused resolve changed the ticket: %+v, %v", after, err)
+	}
+}
+
+// backdate moves the created_at of ticket id to ts
+func backdate(t *testing.T, id TicketID, ts string) {
+	t.Helper()
+	if _, err := db.ExecContext(context.Background(), "UPDATE tickets SET created_at = ? WHERE id = ?", ts, id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// listIDs is the ids of the tickets GET /api/tickets answers target with, in order
+func listIDs(t *testing.T, target string) []TicketID {
+	t.Helper()
+	w := serve(listTicketsHandler, "GET", target, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, w.Code, w.Body)
+	}
+	var page TicketPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	ids := []TicketID{}
+	for _, tk := range page.Data {
+		ids = append(ids, tk.ID)
+	}
+	return ids
+}
+
+func TestListCreatedRange(t *testing.T) {
+	openTestDB(t)
+	first := createTestTicket(t, Ticket{Priority: "high"})
+	second := createTestTicket(t, Ticket{Priority: "high"})
+	third := createTestTicket(t, Ticket{Priority: "low"})
+	backdate(t, first.ID, "2024-05-01 10:00:00")
+	backdate(t, second.ID, "2024-05-08 10:00:00")
+	backdate(t, third.ID, "2024-05-15 10:00:00")
+
+	for _, tc := range []struct {
+		query string
+		want  []TicketID
+	}{
+		{"", []TicketID{third.ID, second.ID, first.ID}},
+		{"created_after=2024-05-05T00:00:00Z", []TicketID{third.ID, second.ID}},
+		{"created_before=2024-05-10T00:00:00Z", []TicketID{second.ID, first.ID}},
+		{"created_after=2024-05-05T00:00:00Z&created_before=2024-05-10T00:00:00Z", []TicketID{second.ID}},
+		// both bounds are inclusive, and an offset is converted before comparing
+		{"created_after=2024-05-08T17:00:00%2B07:00", []TicketID{third.ID, second.ID}},
+		{"created_before=2024-05-08T10:00:00Z", []TicketID{second.ID, first.ID}},
+		{"created_after=2024-06-01T00:00:00Z", []TicketID{}},
+		{"created_after=2024-05-05T00:00:00Z&priority=high", []TicketID{second.ID}},
+		{"created_before=2024-05-10T00:00:00Z&status=open&priority=low", []TicketID{}},
+	} {
+		if got := listIDs(t, "/api/tickets?"+tc.query); !slices.Equal(got, tc.want) {
+			t.Errorf("?%s: %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestListCreatedRangeInvalid(t *testing.T) {
+	openTestDB(t)
+	for _, query := range []string{
+		"created_after=2024-05-01",
+		"created_before=yesterday",
+		"created_after=2024-05-01T00:00:00",
+		"created_after=2024-05-01T00:00:00Z&created_before=1714521600",
+	} {
+		w := serve(listTicketsHandler, "GET", "/api/tickets?"+query, "")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("?%s: %d, want 400", query, w.Code)
+			continue
+		}
+		if e, _ := decodeBody(t, w)["error"].(map[string]interface{}); !strings.Contains(fmt.Sprint(e["message"]), "RFC3339") {
+			t.Errorf("?%s: message %q does not name the expected format", query, e["message"])
+		}
+	}
+}
+
+func TestDefaultSortBreaksTies(t *testing.T) {
+	openTestDB(t)
+	prev := defaultOrderBy
+	t.Cleanup(func() { defaultOrderBy = prev })
+	useBroadcaster(t)
+	var ids []TicketID
+	for _, p := range []string{"low", "high", "high", "medium", "high"} {
+		ids = append(ids, createTestTicket(t, Ticket{Description: "AC bocor", Priority: p}).ID)
+	}
+	// all in the same second, so only the id tells them apart
+	if _, err := db.ExecContext(context.Background(), "UPDATE tickets SET created_at = '2026-10-01 08:00:00', updated_at = '2026-10-01 08:00:00'"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(adminWsHandler))
+	t.Cleanup(srv.Close)
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/admin?token=" + testToken(t, "viewer")
+	initIDs := func() []TicketID {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var msg struct {
+				Event   string `json:"event"`
+				Payload struct {
+					Tickets []Ticket `json:"tickets"`
+				} `json:"payload"`
+			}
+			if err := c.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Event != "init" {
+				continue
+			}
+			// sendWsInit queued the init under broad.mu, so taking it orders its read of
+			// defaultOrderBy before the next case changes it
+			broad.Count()
+			got := []TicketID{}
+			for _, tk := range msg.Payload.Tickets {
+				got = append(got, tk.ID)
+			}
+			return got
+		}
+	}
+
+	for _, tc := range []struct {
+		sort string
+		want []TicketID
+	}{
+		{"-created_at", []TicketID{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"created_at", ids},
+		{"-updated_at", []TicketID{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"-priority", []TicketID{ids[4], ids[2], ids[1], ids[3], ids[0]}},
+		{"priority", []TicketID{ids[0], ids[3], ids[1], ids[2], ids[4]}},
+	} {
+		order, err := sortClause(tc.sort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defaultOrderBy = order
+		if got := listIDs(t, "/api/tickets"); !slices.Equal(got, tc.want) {
+			t.Errorf("-default-sort=%s: list %v, want %v", tc.sort, got, tc.want)
+		}
+		// pages neither repeat nor skip a ticket
+		var paged []TicketID
+		for page := 1; page <= 3; page++ {
+			paged = append(paged, listIDs(t, fmt.Sprintf("/api/tickets?per_page=2&page=%d", page))...)
+		}
+		if !slices.Equal(paged, tc.want) {
+			t.Errorf("-default-sort=%s: pages of 2 gave %v, want %v", tc.sort, paged, tc.want)
+		}
+		if got := initIDs(); !slices.Equal(got, tc.want) {
+			t.Errorf("-default-sort=%s: websocket init %v, want %v", tc.sort, got, tc.want)
+		}
+	}
+}
+
+func TestListLastModified(t *testing.T) {
+	openTestDB(t)
+	prev := listLastModified
+	t.Cleanup(func() { listLastModified = prev })
+	listLastModified = true
+	touch := func(id TicketID, ts string) {
+		t.Helper()
+		if _, err := db.ExecContext(context.Background(), "UPDATE tickets SET updated_at = ? WHERE id = ?", ts, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(target, since string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if since != "" {
+			r.Header.Set("If-Modified-Since", since)
+		}
+		w := httptest.NewRecorder()
+		listTicketsHandler(w, r)
+		return w
+	}
+	open := createTestTicket(t, Ticket{Description: "AC bocor"})
+	closed := createTestTicket(t, Ticket{Description: "lampu mati", Status: "closed"})
+	// the fraction must not make the stored time look newer than its own Last-Modified
+	touch(open.ID, "2026-10-01 08:00:00.75")
+	touch(closed.ID, "2026-10-01 07:00:00")
+
+	w := get("/api/tickets", "")
+	mod := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || mod != "Thu, 01 Oct 2026 08:00:00 GMT" {
+		t.Fatalf("first GET: %d, Last-Modified %q", w.Code, mod)
+	}
+	if w = get("/api/tickets", mod); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("no change: %d %q, want an empty 304", w.Code, w.Body)
+	}
+	if w = get("/api/tickets", "Thu, 01 Oct 2026 07:59:59 GMT"); w.Code != http.StatusOK {
+		t.Errorf("older If-Modified-Since: %d, want 200", w.Code)
+	}
+
+	// a change to a ticket in the result moves the date, one outside the filter does not
+	touch(closed.ID, "2026-10-01 09:30:00")
+	if w = get("/api/tickets?status=open", mod); w.Code != http.StatusNotModified {
+		t.Errorf("change outside the filter: %d, want 304", w.Code)
+	}
+	w = get("/api/tickets", mod)
+	if w.Code != http.StatusOK || w.Header().Get("Last-Modified") != "Thu, 01 Oct 2026 09:30:00 GMT" {
+		t.Errorf("after a change: %d, Last-Modified %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+	if ids := listIDs(t, "/api/tickets?status=closed"); !slices.Equal(ids, []TicketID{closed.ID}) {
+		t.Errorf("list after the change: %v", ids)
+	}
+
+	listLastModified = false
+	if w = get("/api/tickets", mod); w.Code != http.StatusOK || w.Header().Get("Last-Modified") != "" {
+		t.Errorf("without -list-last-modified: %d, Last-Modified %q", w.Code, w.Header().Get("Last-Modified"))
+	}
+}
+
+func TestTimestampsInDisplayZone(t *testing.T) {
+	openTestDB(t)
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skip(err)
+	}
+	prev := displayLoc
+	t.Cleanup(func() { displayLoc = prev })
+	tk := createTestTicket(t, Ticket{})
+	for _, tc := range []struct {
+		loc    *time.Location
+		suffix string
+	}{{time.UTC, "Z"}, {jakarta, "+07:00"}} {
+		displayLoc = tc.loc
+		w := serve(getTicketHandler, "GET", "/api/tickets/x", "", "id", fmt.Sprint(tk.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET: %d %s", w.Code, w.Body)
+		}
+		got := decodeBody(t, w)
+		for _, field := range []string{"created_at", "updated_at", "due_at"} {
+			s, _ := got[field].(string)
+			ts, err := time.Parse(time.RFC3339, s)
+			if err != nil || !strings.HasSuffix(s, tc.suffix) {
+				t.Errorf("-tz %s: %s = %q, want RFC3339 ending in %s", tc.loc, field, s, tc.suffix)
+				continue
+			}
+			// the same instant whatever the zone
+			if field == "created_at" && !ts.Equal(tk.CreatedAt) {
+				t.Errorf("-tz %s: created_at %s, stored %s", tc.loc, ts, tk.CreatedAt)
+			}
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLen        = 120
+	maxRoomLen        = 100
+	maxDescriptionLen = 2000
+)
+
+// defaultCountry is the calling code given to national numbers like 0812... (-default-country)
+var defaultCountry = "62"
+
+// countryCode is what -default-country accepts, without the +
+var countryCode = regexp.MustCompile(`^[1-9][0-9]{0,2}$`)
+
+// phoneFormatting is what reporters type between the digits
+var phoneFormatting = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "", "/", "")
+
+// e164Digits is an E.164 number without its +: a calling code and subscriber number, 15 digits at most
+var e164Digits = regexp.MustCompile(`^[1-9][0-9]{6,14}$`)
+
+// normalizePhone returns raw in E.164 form ("0812-3456" becomes "+628123456" with the default
+// country 62), or "" when it can't be read as a phone number. Numbers written with + or the
+// international prefix 00 keep their own country; others are taken as national numbers.
+func normalizePhone(raw string) string {
+	s := phoneFormatting.Replace(strings.TrimSpace(raw))
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		s = s[2:]
+	case strings.HasPrefix(s, "0"):
+		s = defaultCountry + s[1:]
+	case !strings.HasPrefix(s, defaultCountry):
+		s = defaultCountry + s
+	}
+	if !e164Digits.MatchString(s) {
+		return ""
+	}
+	return "+" + s
+}
+
+// sanitizeHTML strips markup from ticket names, rooms and descriptions on write (-sanitize-html)
+var sanitizeHTML = true
+
+// htmlTag matches a tag, also one cut off at the end of the text, a comment or a doctype.
+// A "<" not followed by a letter, "/" or "!" is ordinary text like "suhu < 20" and stays.
+var htmlTag = regexp.MustCompile(`(?s)<!--.*?(-->|$)|</?[a-zA-Z][^>]*(>|$)|<![^>]*(>|$)`)
+
+// scriptOrStyle matches script and style elements, whose content is code rather than anything the reporter wrote
+var scriptOrStyle = regexp.MustCompile(`(?is)<(script|style)\b[^>]*>.*?</(script|style)\s*>`)
+
+// stripHTML removes the tags from s and keeps the text between them. It repeats until nothing
+// matches, so tags hidden inside tags like "<scr<script>ipt>" don't survive either.
+func stripHTML(s string) string {
+	for {
+		out := htmlTag.ReplaceAllString(scriptOrStyle.ReplaceAllString(s, ""), "")
+		if out == s {
+			return out
+		}
+		s = out
+	}
+}
+
+// sanitizeText strips the markup from every non-nil field when -sanitize-html is on
+func sanitizeText(fields ...*string) {
+	if !sanitizeHTML {
+		return
+	}
+	for _, f := range fields {
+		if f != nil {
+			*f = stripHTML(*f)
+		}
+	}
+}
+
+// FieldErrors maps a JSON field name to what is wrong with it
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f + ": " + e[f]
+	}
+	return "validation failed: " + strings.Join(parts, "; ")
+}
+
+// anonymousName is stored as the name of anonymous tickets
+const anonymousName = "Anonim"
+
+// anonymize replaces the reporter's identity on an anonymous ticket
+func anonymize(t *Ticket) {
+	if t.Anonymous {
+		t.Name = anonymousName
+		t.Phone = ""
+	}
+}
+
+// applyTicketDefaults fills in the status and priority a client omitted
+func applyTicketDefaults(t *Ticket) {
+	if t.Status == "" {
+		t.Status = "open"
+	}
+	if t.Priority == "" {
+		t.Priority = "medium"
+	}
+}
+
+// validateTicket checks a ticket before it is written, returning FieldErrors when invalid.
+// Anonymous tickets need no name or phone, but then the description has to say what is wrong.
+func validateTicket(t Ticket) error {
+	errs := FieldErrors{}
+	if t.Anonymous {
+		if strings.TrimSpace(t.Description) == "" {
+			errs["description"] = "is required for anonymous tickets"
+		}
+	} else {
+		name := strings.TrimSpace(t.Name)
+		switch {
+		case name == "":
+			errs["name"] = "is required"
+		case utf8.RuneCountInString(name) > maxNameLen:
+			errs["name"] = fmt.Sprintf("must be at most %d characters", maxNameLen)
+		}
+		if normalizePhone(t.Phone) == "" {
+			errs["phone"] = "must be a valid phone number"
+		}
+	}
+	room := strings.TrimSpace(t.Room)
+	switch {
+	case room == "":
+		errs["room"] = "is required"
+	case utf8.RuneCountInString(room) > maxRoomLen:
+		errs["room"] = fmt.Sprintf("must be at most %d characters", maxRoomLen)
+	}
+	if utf8.RuneCountInString(t.Description) > maxDescriptionLen {
+		errs["description"] = fmt.Sprintf("must be at most %d characters", maxDescriptionLen)
+	}
+	if !slices.Contains(allowedStatuses, t.Status) {
+		errs["status"] = "must be one of " + strings.Join(allowedStatuses, ", ")
+	}
+	if !slices.Contains(allowedPriorities, t.Priority) {
+		errs["priority"] = "must be one of " + strings.Join(allowedPriorities, ", ")
+	}
+	maps.Copy(errs, validateLocation(t.Lat, t.Lng))
+	maps.Copy(errs, validateMetadata(t.Metadata))
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateLocation checks a map pin: lat and lng come together, within -90..90 and -180..180.
+// A missing half is reported on the half that was sent, so PATCH can tell which field is wrong.
+func validateLocation(lat, lng *float64) FieldErrors {
+	errs := FieldErrors{}
+	switch {
+	case lat != nil && lng == nil:
+		errs["lat"] = "needs lng as well"
+	case lng != nil && lat == nil:
+		errs["lng"] = "needs lat as well"
+	}
+	if lat != nil && (*lat < -90 || *lat > 90) {
+		errs["lat"] = "must be between -90 and 90"
+	}
+	if lng != nil && (*lng < -180 || *lng > 180) {
+		errs["lng"] = "must be between -180 and 180"
+	}
+	return errs
+}
+
+// statusTransitions lists where each status may move next. Keeping the current status is always
+// allowed. Reopening is not in here: it needs a reason and goes through POST /api/tickets/{id}/reopen.
+var statusTransitions = map[string][]string{
+	// an open ticket fixed on the spot is resolved without being worked on first
+	"open":        {"in_progress", "resolved"},
+	"in_progress": {"resolved"},
+	"resolved":    {"closed"},
+	"closed":      {},
+	// a ticket with a dirty status (see checkEnumValues) can be set to any valid one
+	unknownEnum: allowedStatuses,
+}
+
+// reopenableStatuses are the statuses POST /api/tickets/{id}/reopen accepts
+var reopenableStatuses = []string{"resolved", "closed"}
+
+// reasonRequiredStatuses are the statuses a ticket only moves into with a status_reason
+var reasonRequiredStatuses = []string{"resolved", "closed"}
+
+// checkStatusReason validates the status_reason sent with a change from -> to and returns it
+// trimmed; it is dropped when the status stays the same
+func checkStatusReason(from, to string, reason *string) (string, error) {
+	if from == to {
+		return "", nil
+	}
+	var s string
+	if reason != nil {
+		s = strings.TrimSpace(*reason)
+	}
+	switch {
+	case s == "" && slices.Contains(reasonRequiredStatuses, to):
+		return "", FieldErrors{"status_reason": "is required when the status changes to " + to}
+	case utf8.RuneCountInString(s) > maxCommentLen:
+		return "", FieldErrors{"status_reason": "is too long"}
+	}
+	return s, nil
+}
+
+// checkTransition returns an error naming the allowed next states when from -> to isn't permitted
+func checkTransition(from, to string) error {
+	if from == to || slices.Contains(statusTransitions[from], to) {
+		return nil
+	}
+	if to == "open" && slices.Contains(reopenableStatuses, from) {
+		return fmt.Errorf("cannot change status from %s to open directly, reopen the ticket with a reason", from)
+	}
+	next := statusTransitions[from]
+	if len(next) == 0 {
+		return fmt.Errorf("cannot change status from %s", from)
+	}
+	return fmt.Errorf("cannot change status from %s to %s (allowed: %s)", from, to, strings.Join(next, ", "))
+}
